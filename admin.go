@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminUserResponse is the view of a user handed to admins, it never includes password hashes or token secrets
+type adminUserResponse struct {
+	Id          int    `json:"id"`
+	Email       string `json:"email"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Red         bool   `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+	Suspended   bool   `json:"is_suspended"`
+}
+
+func newAdminUserResponse(user User) adminUserResponse {
+	role := user.Role
+	if role == "" {
+		role = roleUser
+	}
+
+	return adminUserResponse{
+		Id:          user.Id,
+		Email:       user.Email,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Red:         user.Red,
+		Role:        role,
+		Suspended:   user.Suspended,
+	}
+}
+
+// adminUserTarget resolves the {userID} path value shared by all admin user endpoints
+func (cfg *apiConfig) adminUserTarget(w http.ResponseWriter, r *http.Request) (User, bool) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return User{}, false
+	}
+
+	user, ok := cfg.database.getUser(userID)
+	if !ok {
+		respondWithError(w, 404, "User does not exist")
+		return User{}, false
+	}
+
+	return user, true
+}
+
+// revokeSessions invalidates the refresh token and every access token issued so far
+func revokeSessions(user *User) {
+	now := time.Now().UTC()
+	user.RefreshTokenSecret = nil
+	user.TokensRevokedAt = &now
+}
+
+func (cfg *apiConfig) handlerAdminGetUsers(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(r.URL.Query().Get("q"))
+	role := r.URL.Query().Get("role")
+	suspendedOnly := r.URL.Query().Get("suspended") == "true"
+
+	userMap := []adminUserResponse{}
+	for _, user := range cfg.database.listUsers() {
+		view := newAdminUserResponse(user)
+		matches := strings.Contains(strings.ToLower(user.Email), query) ||
+			strings.Contains(strings.ToLower(user.Username), query) ||
+			strings.Contains(strings.ToLower(user.DisplayName), query) ||
+			query == strconv.Itoa(user.Id)
+		if query != "" && !matches {
+			continue
+		}
+		if role != "" && view.Role != role {
+			continue
+		}
+		if suspendedOnly && !user.Suspended {
+			continue
+		}
+
+		userMap = append(userMap, view)
+	}
+
+	respondWithJSON(w, 200, userMap)
+}
+
+func (cfg *apiConfig) handlerAdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	chirpCount := 0
+	for _, chirp := range cfg.database.listChirps() {
+		if chirp.AuthorId == user.Id {
+			chirpCount++
+		}
+	}
+
+	type userDetailsResponse struct {
+		adminUserResponse
+		ChirpCount      int        `json:"chirp_count"`
+		HasSession      bool       `json:"has_active_session"`
+		TokensRevokedAt *time.Time `json:"tokens_revoked_at"`
+	}
+
+	respondWithJSON(w, 200, userDetailsResponse{
+		adminUserResponse: newAdminUserResponse(user),
+		ChirpCount:        chirpCount,
+		HasSession:        user.RefreshTokenSecret != nil,
+		TokensRevokedAt:   user.TokensRevokedAt,
+	})
+}
+
+func (cfg *apiConfig) handlerUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var params parameters
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	if !validRole(params.Role) {
+		respondWithError(w, 400, "Role must be one of user, moderator or admin")
+		return
+	}
+
+	if user.Id == admin.Id && params.Role != roleAdmin {
+		// Demoting yourself could leave the instance without any admin
+		respondWithError(w, 400, "Admins cannot change their own role")
+		return
+	}
+
+	previous := newAdminUserResponse(user).Role
+	user, err := cfg.database.updateUser(user.Id, func(u *User) error {
+		u.Role = params.Role
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+
+	cfg.recordAudit(admin, auditRoleChanged, user.Id, fmt.Sprintf("%s -> %s", previous, user.Role))
+	respondWithJSON(w, 200, newAdminUserResponse(user))
+}
+
+func (cfg *apiConfig) handlerSuspendUser(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Id == admin.Id {
+		respondWithError(w, 400, "Admins cannot suspend themselves")
+		return
+	}
+
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	// The reason is optional, so an empty body is fine
+	var params parameters
+	json.NewDecoder(r.Body).Decode(&params)
+
+	user, err := cfg.database.setSuspended(user.Id, true)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+	cfg.sockets.disconnectUser(user.Id, errSuspended.Error())
+
+	cfg.recordAudit(admin, auditSuspended, user.Id, params.Reason)
+	respondWithJSON(w, 200, newAdminUserResponse(user))
+}
+
+func (cfg *apiConfig) handlerUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.database.setSuspended(user.Id, false)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+
+	cfg.recordAudit(admin, auditUnsuspended, user.Id, "")
+	respondWithJSON(w, 200, newAdminUserResponse(user))
+}
+
+func (cfg *apiConfig) handlerRevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.database.updateUser(user.Id, func(u *User) error {
+		revokeSessions(u)
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+
+	cfg.sockets.disconnectUser(user.Id, "Session was revoked")
+	cfg.recordAudit(admin, auditSessionsRevoked, user.Id, "")
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUpdateUserRed(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Red *bool `json:"is_chirpy_red"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var params parameters
+	if err := decoder.Decode(&params); err != nil || params.Red == nil {
+		respondWithError(w, 400, "is_chirpy_red must be given")
+		return
+	}
+
+	user, err := cfg.database.updateUser(user.Id, func(u *User) error {
+		u.Red = *params.Red
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+
+	cfg.recordAudit(admin, auditRedChanged, user.Id, strconv.FormatBool(user.Red))
+	respondWithJSON(w, 200, newAdminUserResponse(user))
+}
+
+func (cfg *apiConfig) handlerAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	user, ok := cfg.adminUserTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Id == admin.Id {
+		respondWithError(w, 400, "Admins cannot delete themselves here, use DELETE /api/users")
+		return
+	}
+
+	type parameters struct {
+		AnonymizeChirps bool `json:"anonymize_chirps"`
+	}
+
+	// Chirps are deleted unless asked otherwise, so an empty body is fine
+	var params parameters
+	json.NewDecoder(r.Body).Decode(&params)
+
+	purgeAt, err := cfg.scheduleDeletion(user, params.AnonymizeChirps)
+	if err != nil {
+		respondWithError(w, 500, "Failed to delete user")
+		return
+	}
+
+	details := "chirps deleted"
+	if params.AnonymizeChirps {
+		details = "chirps anonymized"
+	}
+	cfg.recordAudit(admin, auditDeleted, user.Id, details)
+
+	respondWithJSON(w, 202, deletionResponse{user.Id, purgeAt})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	defaultMaxUploadSize = 5 << 20
+	thumbnailSize        = 320
+	maxChirpAttachments  = 4
+	// attachmentClaimWindow is how long an upload waits for a chirp to be attached to before it is purged
+	attachmentClaimWindow = time.Hour
+)
+
+var errUploadTooLarge = errors.New("Upload is too large")
+
+// processedImage is an upload ready to be stored, re-encoded so no metadata like EXIF survives
+type processedImage struct {
+	contentType string
+	ext         string
+	data        []byte
+	thumbnail   []byte
+	width       int
+	height      int
+}
+
+// processAttachment sniffs, strips and thumbnails an uploaded image. GIF thumbnails are a still of the
+// first frame stored as PNG
+func processAttachment(data []byte) (processedImage, error) {
+	contentType, err := sniffImage(data)
+	if err != nil {
+		return processedImage{}, err
+	}
+	processed := processedImage{contentType: contentType, ext: imageFormats[contentType].ext}
+
+	var img *image.RGBA
+	if contentType == "image/gif" {
+		processed.data, img, err = reencodeGif(data)
+		if err != nil {
+			return processedImage{}, err
+		}
+	} else {
+		img, err = decodeImage(data, contentType)
+		if err != nil {
+			return processedImage{}, err
+		}
+		processed.data, err = encodeImage(img, contentType)
+		if err != nil {
+			return processedImage{}, err
+		}
+	}
+	processed.width, processed.height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	thumbType := contentType
+	if thumbType == "image/gif" {
+		thumbType = "image/png"
+	}
+	thumbWidth, thumbHeight := fitWithin(processed.width, processed.height, thumbnailSize)
+	processed.thumbnail, err = encodeImage(resizeImage(img, thumbWidth, thumbHeight), thumbType)
+	if err != nil {
+		return processedImage{}, err
+	}
+
+	return processed, nil
+}
+
+// readUpload reads the file in the named part of a multipart request, uploads larger than limit are refused
+// with errUploadTooLarge
+func readUpload(w http.ResponseWriter, r *http.Request, field string, limit int64) ([]byte, error) {
+	// The rest of the form is small, a little room on top of the file is enough for it
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("Request must be multipart/form-data")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("Missing %s file", field)
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errUploadTooLarge
+		}
+		if err != nil {
+			return nil, errors.New("Malformed multipart body")
+		}
+		if part.FormName() != field {
+			continue
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, limit+1))
+		if errors.As(err, &maxBytesErr) || int64(len(data)) > limit {
+			return nil, errUploadTooLarge
+		}
+		if err != nil {
+			return nil, errors.New("Malformed multipart body")
+		}
+
+		return data, nil
+	}
+}
+
+// respondWithUploadError picks the status for what went wrong reading or processing an uploaded image
+func respondWithUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		respondWithError(w, 413, err.Error())
+	case errors.Is(err, errUnsupportedImage):
+		respondWithError(w, 415, err.Error())
+	default:
+		respondWithError(w, 400, err.Error())
+	}
+}
+
+type attachmentResponse struct {
+	Id           string `json:"id"`
+	Url          string `json:"url"`
+	ThumbnailUrl string `json:"thumbnail_url"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Size         int    `json:"size"`
+}
+
+func (cfg *apiConfig) newAttachmentResponse(a Attachment) attachmentResponse {
+	return attachmentResponse{
+		Id:           a.Id,
+		Url:          cfg.blobs.url(a.Key),
+		ThumbnailUrl: cfg.blobs.url(a.ThumbnailKey),
+		ContentType:  a.ContentType,
+		Width:        a.Width,
+		Height:       a.Height,
+		Size:         a.Size,
+	}
+}
+
+// chirpAttachments are the attachments of a chirp in the order they were given
+func (cfg *apiConfig) chirpAttachments(chirp Chirp) []attachmentResponse {
+	attachments := []attachmentResponse{}
+	for _, id := range chirp.AttachmentIds {
+		if a, ok := cfg.database.getAttachment(id); ok {
+			attachments = append(attachments, cfg.newAttachmentResponse(a))
+		}
+	}
+
+	return attachments
+}
+
+// handlerUploadAttachment takes an image as the "image" field of a multipart form, the returned id is
+// then passed in attachment_ids when creating a chirp
+func (cfg *apiConfig) handlerUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	data, err := readUpload(w, r, "image", cfg.maxUploadSize)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	processed, err := processAttachment(data)
+	if err != nil {
+		respondWithUploadError(w, err)
+		return
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, 500, "Failed to create attachment")
+		return
+	}
+
+	attachment := Attachment{
+		Id:           id,
+		UserId:       user.Id,
+		ContentType:  processed.contentType,
+		Width:        processed.width,
+		Height:       processed.height,
+		Size:         len(processed.data),
+		Key:          id + processed.ext,
+		ThumbnailKey: id + "_thumb" + processed.ext,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if processed.contentType == "image/gif" {
+		attachment.ThumbnailKey = id + "_thumb.png"
+	}
+
+	if err := cfg.blobs.put(attachment.Key, processed.data); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store attachment %s: %s\n", id, err)
+		respondWithError(w, 500, "Failed to store attachment")
+		return
+	}
+	if err := cfg.blobs.put(attachment.ThumbnailKey, processed.thumbnail); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store thumbnail of attachment %s: %s\n", id, err)
+		cfg.blobs.remove(attachment.Key)
+		respondWithError(w, 500, "Failed to store attachment")
+		return
+	}
+
+	cfg.database.storeAttachment(attachment)
+
+	respondWithJSON(w, 201, cfg.newAttachmentResponse(attachment))
+}
+
+// claimableAttachments checks the attachment ids given when creating a chirp, each has to be an upload
+// of author that is not attached to a chirp yet
+func (cfg *apiConfig) claimableAttachments(author User, ids []string) error {
+	if len(ids) > maxChirpAttachments {
+		return fmt.Errorf("A chirp can have at most %d attachments", maxChirpAttachments)
+	}
+
+	for i, id := range ids {
+		attachment, ok := cfg.database.getAttachment(id)
+		if !ok || attachment.UserId != author.Id || attachment.ChirpId != nil {
+			return fmt.Errorf("Attachment %s does not exist", id)
+		}
+		if slices.Contains(ids[:i], id) {
+			return fmt.Errorf("Attachment %s is given more than once", id)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) removeAttachment(attachment Attachment) {
+	for _, key := range []string{attachment.Key, attachment.ThumbnailKey} {
+		if err := cfg.blobs.remove(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove blob %s: %s\n", key, err)
+			return
+		}
+	}
+	cfg.database.deleteAttachment(attachment.Id)
+}
+
+// purgeAttachments removes uploads that were never attached to a chirp and those whose chirp is gone
+func (cfg *apiConfig) purgeAttachments() {
+	cutoff := time.Now().UTC().Add(-attachmentClaimWindow)
+
+	for _, attachment := range cfg.database.listAttachments() {
+		if attachment.ChirpId == nil {
+			if attachment.CreatedAt.Before(cutoff) {
+				cfg.removeAttachment(attachment)
+			}
+			continue
+		}
+
+		if _, ok := cfg.database.getChirp(*attachment.ChirpId); !ok {
+			cfg.removeAttachment(attachment)
+		}
+	}
+}
+
+// Attachment is an uploaded image, it belongs to a chirp once ChirpId is set
+type Attachment struct {
+	Id           string    `json:"id"`
+	UserId       int       `json:"user_id"`
+	ChirpId      *int      `json:"chirp_id"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Size         int       `json:"size"`
+	Key          string    `json:"key"`
+	ThumbnailKey string    `json:"thumbnail_key"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	auditRoleChanged     = "user.role_changed"
+	auditSuspended       = "user.suspended"
+	auditUnsuspended     = "user.unsuspended"
+	auditSessionsRevoked = "user.sessions_revoked"
+	auditRedChanged      = "user.red_changed"
+	auditDeleted         = "user.deleted"
+
+	auditProfanityReloaded = "profanity.reloaded"
+)
+
+// recordAudit keeps a trail of every privileged action, failing to record is logged but never blocks the action
+func (cfg *apiConfig) recordAudit(actor User, action string, targetUserId int, details string) {
+	entry := AuditEntry{
+		ActorId:      actor.Id,
+		Action:       action,
+		TargetUserId: targetUserId,
+		Details:      details,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if _, err := cfg.database.storeAuditEntry(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store audit entry: %s\n", err)
+	}
+}
+
+func (cfg *apiConfig) handlerGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	var targetId *int
+	if queryTarget := r.URL.Query().Get("user_id"); queryTarget != "" {
+		id, err := strconv.Atoi(queryTarget)
+		if err != nil {
+			respondWithError(w, 400, "user_id is not a valid number")
+			return
+		}
+
+		targetId = &id
+	}
+
+	entries := []AuditEntry{}
+	for _, entry := range cfg.database.listAuditEntries() {
+		if targetId == nil || *targetId == entry.TargetUserId || *targetId == entry.ActorId {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Newest first, that is what anyone looking at an audit trail is after
+	slices.Reverse(entries)
+
+	respondWithJSON(w, 200, entries)
+}
+
+type AuditEntry struct {
+	Id           int       `json:"id"`
+	ActorId      int       `json:"actor_id"`
+	Action       string    `json:"action"`
+	TargetUserId int       `json:"target_user_id"`
+	Details      string    `json:"details"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+var (
+	errMissingToken = errors.New("Authorization token is missing")
+	errInvalidToken = errors.New("Authorization token is invalid")
+	errUnknownUser  = errors.New("User does not exist")
+	errSuspended    = errors.New("Account is suspended")
+	errRevoked      = errors.New("Authorization token has been revoked")
+)
+
+// roleRank orders roles so that a higher role implies every lower one.
+func roleRank(role string) int {
+	switch role {
+	case roleAdmin:
+		return 2
+	case roleModerator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func validRole(role string) bool {
+	return role == roleUser || role == roleModerator || role == roleAdmin
+}
+
+// respondWithAuthError reports a failed authenticate, suspended accounts are authenticated but forbidden
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSuspended) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+
+	respondWithError(w, 401, err.Error())
+}
+
+func getBearerToken(headers http.Header) (string, error) {
+	bearer := headers.Get("Authorization")
+	if bearer == "" || !strings.HasPrefix(bearer, "Bearer ") {
+		return "", errMissingToken
+	}
+
+	return strings.TrimPrefix(bearer, "Bearer "), nil
+}
+
+func (cfg *apiConfig) parseToken(bearer string) (*jwt.Token, error) {
+	token, err := jwt.ParseWithClaims(
+		bearer,
+		&jwt.RegisteredClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			return []byte(cfg.jwtSecret), nil
+		},
+	)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	return token, nil
+}
+
+// authenticate resolves the user behind the access token in the Authorization header
+func (cfg *apiConfig) authenticate(r *http.Request) (User, error) {
+	bearer, err := getBearerToken(r.Header)
+	if err != nil {
+		return User{}, err
+	}
+
+	user, _, err := cfg.authenticateToken(bearer)
+	return user, err
+}
+
+// authenticateToken resolves the user behind an access token, for connections that outlive a single request
+// it also hands back the token so its expiry can be watched
+func (cfg *apiConfig) authenticateToken(bearer string) (User, *jwt.Token, error) {
+	token, err := cfg.parseToken(bearer)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	subject, err := token.Claims.GetSubject()
+	if err != nil {
+		return User{}, nil, errInvalidToken
+	}
+
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return User{}, nil, errInvalidToken
+	}
+
+	user, ok := cfg.database.getUser(userID)
+	if !ok || user.DeletedAt != nil {
+		return User{}, nil, errUnknownUser
+	}
+
+	if user.Suspended {
+		return User{}, nil, errSuspended
+	}
+
+	if user.TokensRevokedAt != nil {
+		// Tokens only carry second precision, so a token issued in the same second as the revocation is rejected too
+		issuedAt, err := token.Claims.GetIssuedAt()
+		if err != nil || issuedAt == nil || issuedAt.Before(*user.TokensRevokedAt) {
+			return User{}, nil, errRevoked
+		}
+	}
+
+	return user, token, nil
+}
+
+// optionalViewer is the user behind the request on endpoints that also serve anonymous requests,
+// a missing or unusable token is treated as an anonymous request rather than an error
+func (cfg *apiConfig) optionalViewer(r *http.Request) *User {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		return nil
+	}
+
+	return &user
+}
+
+// middlewareRequireRole only lets requests through from authenticated users holding at least role,
+// the user is made available to next through userFromContext
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+
+		if roleRank(user.Role) < roleRank(role) {
+			respondWithError(w, 403, "Insufficient permissions")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func userFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey).(User)
+	return user, ok
+}
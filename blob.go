@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errBlobNotFound = errors.New("blob does not exist")
+
+// blobStore keeps uploaded files such as image attachments, keys are chosen by the caller and are
+// flat names ending in the file extension, which is what the content type is served from
+type blobStore interface {
+	put(key string, data []byte) error
+	open(key string) (io.ReadSeekCloser, time.Time, error)
+	remove(key string) error
+	// url is where clients fetch a blob from
+	url(key string) string
+}
+
+// diskBlobStore keeps blobs as files in a local directory, served by handlerServeBlob under baseUrl
+type diskBlobStore struct {
+	dir     string
+	baseUrl string
+}
+
+func newDiskBlobStore(dir, baseUrl string) (*diskBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &diskBlobStore{dir: dir, baseUrl: baseUrl}, nil
+}
+
+// validBlobKey keeps keys to plain file names so they cannot reach outside the directory
+func validBlobKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, ".") && !strings.ContainsAny(key, `/\`)
+}
+
+func (s *diskBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", errBlobNotFound
+	}
+
+	return filepath.Join(s.dir, key), nil
+}
+
+// put writes to a temporary file first, so a blob is either there completely or not at all
+func (s *diskBlobStore) put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (s *diskBlobStore) open(key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, errBlobNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+
+	return f, info.ModTime(), nil
+}
+
+func (s *diskBlobStore) remove(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *diskBlobStore) url(key string) string {
+	return s.baseUrl + key
+}
+
+// blobAccess decides whether the request may fetch the blob at key and whether anyone else may too.
+// Attachments follow the chirp they are attached to, uploads not attached yet are only served to the user
+// who uploaded them. Profile images are public as long as they are the current avatar or banner of an account
+// that is not pending deletion, any other key is not served
+func (cfg *apiConfig) blobAccess(r *http.Request, key string) (allowed, public bool) {
+	attachment, ok := cfg.database.getAttachmentByKey(key)
+	if !ok {
+		owner, ok := cfg.database.getUserByProfileImageKey(key)
+		if !ok || owner.DeletedAt != nil {
+			return false, false
+		}
+		return true, true
+	}
+
+	viewer := cfg.optionalViewer(r)
+	if attachment.ChirpId == nil {
+		return viewer != nil && viewer.Id == attachment.UserId, false
+	}
+
+	chirp, ok := cfg.database.getChirp(*attachment.ChirpId)
+	if !ok || !cfg.canView(viewer, chirp) {
+		return false, false
+	}
+
+	return true, cfg.canView(nil, chirp)
+}
+
+// handlerServeBlob serves blobs of stores that have no web server of their own, like diskBlobStore.
+// Keys are never reused for other content, so clients may cache public blobs for good. Everything
+// else is checked again on every request, the chirp it belongs to can be hidden or deleted any time
+func (cfg *apiConfig) handlerServeBlob(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	allowed, public := cfg.blobAccess(r, key)
+	if !allowed {
+		http.NotFound(w, r)
+		return
+	}
+
+	blob, modified, err := cfg.blobs.open(key)
+	if errors.Is(err, errBlobNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open blob %s: %s\n", key, err)
+		w.WriteHeader(500)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if public {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, key, modified, blob)
+}
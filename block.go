@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// inFeed reports whether a chirp belongs in the lists a viewer reads, on top of canView that leaves out
+// the chirps of muted accounts, rechirps of them included. Muted chirps can still be opened directly
+func (cfg *apiConfig) inFeed(viewer *User, chirp Chirp) bool {
+	if !cfg.canView(viewer, chirp) {
+		return false
+	}
+	if viewer == nil {
+		return true
+	}
+
+	if cfg.database.hasMuted(viewer.Id, chirp.AuthorId) {
+		return false
+	}
+	if chirp.RechirpOf != nil {
+		if original, ok := cfg.database.getChirp(*chirp.RechirpOf); ok && cfg.database.hasMuted(viewer.Id, original.AuthorId) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// relationTarget resolves the {userID} path value for blocking and muting, which cannot be done to yourself
+func (cfg *apiConfig) relationTarget(w http.ResponseWriter, r *http.Request) (User, User, bool) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return User{}, User{}, false
+	}
+
+	target, ok := cfg.followTarget(w, r)
+	if !ok {
+		return User{}, User{}, false
+	}
+
+	if target.Id == user.Id {
+		respondWithError(w, 400, "You cannot block or mute yourself")
+		return User{}, User{}, false
+	}
+
+	return user, target, true
+}
+
+func (cfg *apiConfig) handlerBlockUser(w http.ResponseWriter, r *http.Request) {
+	user, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.database.storeBlock(user.Id, target.Id); err != nil {
+		respondWithError(w, 500, "Failed to store block in database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnblockUser(w http.ResponseWriter, r *http.Request) {
+	user, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.database.deleteBlock(user.Id, target.Id); err != nil {
+		respondWithError(w, 500, "Failed to delete block from database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// handlerMuteUser mutes an account, unlike a block the muted user is not told and can still follow
+func (cfg *apiConfig) handlerMuteUser(w http.ResponseWriter, r *http.Request) {
+	user, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.database.storeMute(user.Id, target.Id); err != nil {
+		respondWithError(w, 500, "Failed to store mute in database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnmuteUser(w http.ResponseWriter, r *http.Request) {
+	user, target, ok := cfg.relationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.database.deleteMute(user.Id, target.Id); err != nil {
+		respondWithError(w, 500, "Failed to delete mute from database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+type relationResponse struct {
+	userProfile
+	Since time.Time `json:"since"`
+}
+
+// listRelationUsers serves both the blocks and the mutes of the authenticated user, newest first
+func (cfg *apiConfig) listRelationUsers(w http.ResponseWriter, r *http.Request, list func(userId int) map[int]time.Time) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	users := []relationResponse{}
+	for otherId, since := range list(user.Id) {
+		other, ok := cfg.database.getUser(otherId)
+		if !ok || other.DeletedAt != nil {
+			continue
+		}
+		users = append(users, relationResponse{cfg.newUserProfile(other), since})
+	}
+	slices.SortFunc(users, func(a, b relationResponse) int {
+		return b.Since.Compare(a.Since)
+	})
+
+	users = users[min(offset, len(users)):]
+	respondWithJSON(w, 200, users[:min(limit, len(users))])
+}
+
+func (cfg *apiConfig) handlerGetBlocks(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelationUsers(w, r, func(userId int) map[int]time.Time {
+		since := map[int]time.Time{}
+		for _, block := range cfg.database.listBlocks(userId) {
+			since[block.BlockedId] = block.CreatedAt
+		}
+		return since
+	})
+}
+
+func (cfg *apiConfig) handlerGetMutes(w http.ResponseWriter, r *http.Request) {
+	cfg.listRelationUsers(w, r, func(userId int) map[int]time.Time {
+		since := map[int]time.Time{}
+		for _, mute := range cfg.database.listMutes(userId) {
+			since[mute.MutedId] = mute.CreatedAt
+		}
+		return since
+	})
+}
+
+type Block struct {
+	BlockerId int       `json:"blocker_id"`
+	BlockedId int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Mute struct {
+	MuterId   int       `json:"muter_id"`
+	MutedId   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
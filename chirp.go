@@ -2,53 +2,56 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"slices"
 	"strconv"
-	"strings"
-
-	"github.com/golang-jwt/jwt/v5"
+	"time"
 )
 
-func getProfanityWords() []string {
-	return []string{"kerfuffle", "sharbert", "fornax"}
+// cleanChirpBody runs the checks every chirp body by author has to pass before it is stored, returning the
+// body as it should be stored and whether it has to be reviewed by a moderator
+func (cfg *apiConfig) cleanChirpBody(author User, body string) (string, bool, error) {
+	if len(body) > maxChirpBytes {
+		return "", false, errChirpTooLarge
+	}
+	if length, limit := chirpLength(body), cfg.chirpLimit(author); length > limit {
+		return "", false, chirpTooLongError{Length: length, Limit: limit}
+	}
+
+	return cfg.profanity.apply(body)
 }
 
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	bearer := r.Header.Get("Authorization")
-	if bearer == "" || !strings.Contains(bearer, "Bearer ") {
-		resp := errorResponse{"Authorization token is missing"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(401)
-		w.Write(dat)
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
 		return
 	}
 
-	bearer = strings.Split(bearer, " ")[1]
-	token, err := jwt.ParseWithClaims(
-		bearer,
-		&jwt.RegisteredClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		},
-	)
-	if err != nil {
-		w.WriteHeader(401)
-		return
+	type parameters struct {
+		Body       string `json:"body"`
+		InReplyTo  *int   `json:"in_reply_to"`
+		QuoteOf    *int   `json:"quote_of"`
+		Visibility string `json:"visibility"`
+		// Ids of images uploaded through POST /api/attachments
+		AttachmentIds []string `json:"attachment_ids"`
 	}
 
-	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		resp := errorResponse{"Failed to get ID from jwt"}
+	r.Body = http.MaxBytesReader(w, r.Body, maxChirpRequestBytes)
+	decoder := json.NewDecoder(r.Body)
+	var params parameters
+	if err := decoder.Decode(&params); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, 413, errChirpTooLarge.Error())
+			return
+		}
+		fmt.Fprintf(os.Stderr, "error decoding parameters: %s\n", err)
+
+		resp := errorResponse{"Something went wrong"}
 		dat, err := json.Marshal(resp)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
@@ -62,82 +65,98 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := strconv.Atoi(userId)
+	visibility, err := parseVisibility(params.Visibility)
 	if err != nil {
-		w.WriteHeader(401)
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	var user *User
-	for _, userInDb := range cfg.database.Users {
-		if userInDb.Id == userID {
-			user = &userInDb
-		}
-	}
-
-	if user == nil {
-		w.WriteHeader(401)
+	if err := cfg.claimableAttachments(user, params.AttachmentIds); err != nil {
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	maxLen := 140
-
-	type parameters struct {
-		Body string `json:"body"`
+	body, flagged, err := cfg.cleanChirpBody(user, params.Body)
+	if err != nil {
+		respondWithChirpBodyError(w, err)
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	var params parameters
-	if err := decoder.Decode(&params); err != nil {
-		fmt.Fprintf(os.Stderr, "error decoding parameters: %s\n", err)
-
-		resp := errorResponse{"Something went wrong"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
+	// Replying to or quoting a rechirp is replying to or quoting the chirp that was rechirped
+	var inReplyTo *int
+	if params.InReplyTo != nil {
+		parent, ok := cfg.database.getChirp(*params.InReplyTo)
+		if !ok || !cfg.canView(&user, parent) {
+			respondWithError(w, 404, "Chirp being replied to does not exist")
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		w.Write(dat)
-		return
+		parentId := parent.originalId()
+		inReplyTo = &parentId
 	}
 
-	if len(params.Body) > maxLen {
-		resp := errorResponse{"Chirp is too long"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
+	var quoteOf *int
+	if params.QuoteOf != nil {
+		quoted, ok := cfg.database.getChirp(*params.QuoteOf)
+		if !ok || !cfg.canView(&user, quoted) {
+			respondWithError(w, 404, "Chirp being quoted does not exist")
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(400)
-		w.Write(dat)
-		return
+		quotedId := quoted.originalId()
+		quoteOf = &quotedId
 	}
 
-	parts := strings.Split(params.Body, " ")
-	profanity := getProfanityWords()
-
-	for i, word := range parts {
-		if slices.Contains(profanity, strings.ToLower(word)) {
-			parts[i] = "****"
-		}
+	verdict, err := cfg.classifyChirp(r.Context(), user, body)
+	if err != nil {
+		respondWithError(w, 503, err.Error())
+		return
+	}
+	if verdict.Verdict == verdictReject {
+		respondWithError(w, 400, "Chirp was rejected: "+verdict.Reason)
+		return
 	}
 
-	chirp := Chirp{Body: strings.Join(parts, " "), AuthorId: user.Id}
+	// A quarantined chirp waits hidden in the moderation queue until a moderator lets it through
+	quarantined := verdict.Verdict == verdictQuarantine
+	chirp := Chirp{
+		Body:          body,
+		AuthorId:      user.Id,
+		CreatedAt:     time.Now().UTC(),
+		InReplyTo:     inReplyTo,
+		QuoteOf:       quoteOf,
+		MentionIds:    cfg.resolveMentions(user, body),
+		Visibility:    visibility,
+		AttachmentIds: params.AttachmentIds,
+		Flagged:       flagged || quarantined,
+		Hidden:        quarantined,
+	}
 	chirp, err = cfg.database.storeChirp(chirp)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to store chirp in database")
 		w.WriteHeader(500)
 		return
 	}
+	if quarantined {
+		decision := ModerationDecision{
+			ChirpId:   chirp.Id,
+			AuthorId:  chirp.AuthorId,
+			Action:    moderationQuarantine,
+			Note:      verdict.Reason,
+			CreatedAt: time.Now().UTC(),
+		}
+		if _, err := cfg.database.storeModerationDecision(decision, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store moderation decision: %s\n", err)
+		}
+	}
+	if inReplyTo != nil {
+		if parent, ok := cfg.database.getChirp(*inReplyTo); ok {
+			cfg.notify(parent.AuthorId, notificationReply, user.Id, &chirp.Id)
+		}
+	}
+	cfg.notifyMentions(chirp, nil)
 
-	dat, err := json.Marshal(chirp)
+	dat, err := json.Marshal(cfg.newChirpResponse(&user, chirp))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
 		w.WriteHeader(500)
@@ -162,11 +181,16 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 		authorId = &id
 	}
 	sortDesc := r.URL.Query().Get("sort") == "desc"
+	viewer := cfg.optionalViewer(r)
 
-	chirpMap := []Chirp{}
-	for _, chirp := range cfg.database.Chirps {
-		if authorId == nil || *authorId == chirp.AuthorId {
-			chirpMap = append(chirpMap, chirp)
+	chirpMap := []chirpResponse{}
+	for _, chirp := range cfg.database.listChirps() {
+		// Unlisted chirps are left out of the global list but still show up when listing a single author
+		if authorId == nil && !chirp.listed() {
+			continue
+		}
+		if (authorId == nil || *authorId == chirp.AuthorId) && cfg.inFeed(viewer, chirp) {
+			chirpMap = append(chirpMap, cfg.newChirpResponse(viewer, chirp))
 		}
 	}
 
@@ -198,66 +222,9 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	bearer := r.Header.Get("Authorization")
-	if bearer == "" || !strings.Contains(bearer, "Bearer ") {
-		resp := errorResponse{"Authorization token is missing"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(401)
-		w.Write(dat)
-		return
-	}
-
-	bearer = strings.Split(bearer, " ")[1]
-	token, err := jwt.ParseWithClaims(
-		bearer,
-		&jwt.RegisteredClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		},
-	)
-	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-
-	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		resp := errorResponse{"Failed to get ID from jwt"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		w.Write(dat)
-		return
-	}
-
-	userID, err := strconv.Atoi(userId)
+	user, err := cfg.authenticate(r)
 	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-
-	var user *User
-	for _, userInDb := range cfg.database.Users {
-		if userInDb.Id == userID {
-			user = &userInDb
-		}
-	}
-
-	if user == nil {
-		w.WriteHeader(401)
+		respondWithAuthError(w, err)
 		return
 	}
 
@@ -319,10 +286,10 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 
 	var chirp *Chirp
 
-	for _, chirpInDb := range cfg.database.Chirps {
-		if chirpInDb.Id == lookingFor {
-			chirp = &chirpInDb
-		}
+	viewer := cfg.optionalViewer(r)
+	chirpInDb, ok := cfg.database.getChirp(lookingFor)
+	if ok && cfg.canView(viewer, chirpInDb) {
+		chirp = &chirpInDb
 	}
 
 	if chirp == nil {
@@ -341,19 +308,164 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	data, err := json.Marshal(chirp)
+	data, err := json.Marshal(cfg.newChirpResponse(viewer, *chirp))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	w.Write(data)
 }
 
+// canView reports whether a chirp may be shown to viewer, who is nil for anonymous requests,
+// chirps of accounts pending deletion are hidden until they are purged
+func (cfg *apiConfig) canView(viewer *User, chirp Chirp) bool {
+	author, ok := cfg.database.getUser(chirp.AuthorId)
+	if ok && author.DeletedAt != nil {
+		return false
+	}
+
+	// Blocks work both ways, neither side sees the other's chirps
+	if viewer != nil && chirp.AuthorId != 0 && cfg.database.isBlocked(viewer.Id, chirp.AuthorId) {
+		return false
+	}
+
+	if !cfg.visibleTo(viewer, chirp) {
+		return false
+	}
+
+	// Hidden chirps stay visible to their author and to the moderators deciding on them
+	if chirp.Hidden && (viewer == nil || (viewer.Id != chirp.AuthorId && roleRank(viewer.Role) < roleRank(roleModerator))) {
+		return false
+	}
+
+	return true
+}
+
+// chirpResponse is how a chirp is presented to clients, the stored chirp plus everything derived from it.
+// Mentions are only given as entities, which leave out the users the viewer can not see
+type chirpResponse struct {
+	Id            int        `json:"id"`
+	Body          string     `json:"body"`
+	AuthorId      int        `json:"author_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	EditedAt      *time.Time `json:"edited_at"`
+	InReplyTo     *int       `json:"in_reply_to"`
+	RechirpOf     *int       `json:"rechirp_of"`
+	QuoteOf       *int       `json:"quote_of"`
+	Visibility    string     `json:"visibility"`
+	AttachmentIds []string   `json:"attachment_ids"`
+	// The moderation state is only shown to the author and to moderators
+	Flagged       *bool                `json:"flagged,omitempty"`
+	Hidden        *bool                `json:"hidden,omitempty"`
+	Entities      chirpEntities        `json:"entities"`
+	Attachments   []attachmentResponse `json:"attachments"`
+	ReplyCount    int                  `json:"reply_count"`
+	LikeCount     int                  `json:"like_count"`
+	RechirpCount  int                  `json:"rechirp_count"`
+	QuoteCount    int                  `json:"quote_count"`
+	LikedByMe     *bool                `json:"liked_by_me,omitempty"`
+	RechirpedByMe *bool                `json:"rechirped_by_me,omitempty"`
+	Rechirped     *chirpRef            `json:"rechirped_chirp,omitempty"`
+	Quoted        *chirpRef            `json:"quoted_chirp,omitempty"`
+}
+
+// chirpRef embeds another chirp in a response, a chirp that was deleted or can not be seen only keeps its id
+type chirpRef struct {
+	Id int `json:"id"`
+	*chirpResponse
+	Unavailable bool `json:"unavailable,omitempty"`
+}
+
+// newChirpResponse presents a chirp to viewer, anything personal to the viewer is left out for anonymous requests
+func (cfg *apiConfig) newChirpResponse(viewer *User, chirp Chirp) chirpResponse {
+	resp := cfg.newPlainChirpResponse(viewer, chirp)
+
+	if chirp.RechirpOf != nil {
+		resp.Rechirped = cfg.newChirpRef(viewer, *chirp.RechirpOf)
+	}
+	if chirp.QuoteOf != nil {
+		resp.Quoted = cfg.newChirpRef(viewer, *chirp.QuoteOf)
+	}
+
+	return resp
+}
+
+// newPlainChirpResponse presents a chirp without embedding the chirps it rechirps or quotes
+func (cfg *apiConfig) newPlainChirpResponse(viewer *User, chirp Chirp) chirpResponse {
+	rechirps, quotes := cfg.database.countRechirps(chirp.Id)
+	resp := chirpResponse{
+		Id:            chirp.Id,
+		Body:          chirp.Body,
+		AuthorId:      chirp.AuthorId,
+		CreatedAt:     chirp.CreatedAt,
+		EditedAt:      chirp.EditedAt,
+		InReplyTo:     chirp.InReplyTo,
+		RechirpOf:     chirp.RechirpOf,
+		QuoteOf:       chirp.QuoteOf,
+		Visibility:    chirp.Visibility,
+		AttachmentIds: chirp.AttachmentIds,
+		Entities:      chirpEntities{Hashtags: parseHashtags(chirp.Body), Mentions: cfg.chirpMentionEntities(chirp)},
+		Attachments:   cfg.chirpAttachments(chirp),
+		ReplyCount:    cfg.countVisibleReplies(viewer, chirp.Id),
+		LikeCount:     cfg.database.countLikes(chirp.Id),
+		RechirpCount:  rechirps,
+		QuoteCount:    quotes,
+	}
+
+	if viewer != nil && (viewer.Id == chirp.AuthorId || roleRank(viewer.Role) >= roleRank(roleModerator)) {
+		resp.Flagged = &chirp.Flagged
+		resp.Hidden = &chirp.Hidden
+	}
+
+	if viewer != nil {
+		liked := cfg.database.hasLiked(viewer.Id, chirp.Id)
+		resp.LikedByMe = &liked
+		_, rechirped := cfg.database.findRechirp(viewer.Id, chirp.Id)
+		resp.RechirpedByMe = &rechirped
+	}
+
+	return resp
+}
+
+func (cfg *apiConfig) newChirpRef(viewer *User, chirpId int) *chirpRef {
+	chirp, ok := cfg.database.getChirp(chirpId)
+	if !ok || !cfg.canView(viewer, chirp) {
+		return &chirpRef{Id: chirpId, Unavailable: true}
+	}
+
+	resp := cfg.newPlainChirpResponse(viewer, chirp)
+	return &chirpRef{Id: chirpId, chirpResponse: &resp}
+}
+
 type errorResponse struct {
 	Error string `json:"error"`
 }
 
 type Chirp struct {
-	Id       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorId int    `json:"author_id"`
+	Id        int        `json:"id"`
+	Body      string     `json:"body"`
+	AuthorId  int        `json:"author_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+	InReplyTo *int       `json:"in_reply_to"`
+	RechirpOf *int       `json:"rechirp_of"`
+	QuoteOf   *int       `json:"quote_of"`
+	// Users mentioned when the chirp was written, later username changes do not move mentions around
+	MentionIds []int `json:"mention_ids"`
+	// Who may see the chirp, one of visibilities and fixed when the chirp is written
+	Visibility string `json:"visibility"`
+	// Uploaded images, in the order they are shown
+	AttachmentIds []string `json:"attachment_ids"`
+	// Set when the profanity filter wants a moderator to have a look
+	Flagged bool `json:"flagged"`
+	// Set by moderators or by enough reports, a hidden chirp is only shown to its author and moderators
+	Hidden bool `json:"hidden"`
+}
+
+// originalId is the chirp that is actually being shown, for a rechirp that is the chirp it reposts
+func (c Chirp) originalId() int {
+	if c.RechirpOf != nil {
+		return *c.RechirpOf
+	}
+
+	return c.Id
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+const (
+	verdictAllow      = "allow"
+	verdictReject     = "reject"
+	verdictQuarantine = "quarantine"
+
+	moderationQuarantine = "quarantine"
+
+	defaultClassifierTimeout = 2 * time.Second
+)
+
+var errClassifierUnavailable = errors.New("Chirp could not be checked right now, try again later")
+
+// verdictSeverity orders verdicts so that combining classifiers keeps the strictest one
+func verdictSeverity(verdict string) int {
+	switch verdict {
+	case verdictReject:
+		return 2
+	case verdictQuarantine:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type classification struct {
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason"`
+}
+
+// chirpClassifier decides what happens to a chirp before it is stored, spam and toxicity checks plug in here
+type chirpClassifier interface {
+	classify(ctx context.Context, author User, body string) (classification, error)
+}
+
+// classifierChain runs every classifier in turn and keeps the strictest verdict, a reject ends it early.
+// A classifier that fails does not stop the others, the verdict so far comes back along with the error
+type classifierChain []chirpClassifier
+
+func (c classifierChain) classify(ctx context.Context, author User, body string) (classification, error) {
+	result := classification{Verdict: verdictAllow}
+	var errs []error
+	for _, classifier := range c {
+		next, err := classifier.classify(ctx, author, body)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if verdictSeverity(next.Verdict) > verdictSeverity(result.Verdict) {
+			result = next
+		}
+		if result.Verdict == verdictReject {
+			break
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// classifierRule matches on a pattern, too many links or too many mentions, whichever of them are set
+type classifierRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	MaxLinks    *int   `json:"max_links"`
+	MaxMentions *int   `json:"max_mentions"`
+	Verdict     string `json:"verdict"`
+	Reason      string `json:"reason"`
+
+	pattern *regexp.Regexp
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// defaultClassifierRules catch the most obvious spam when no CLASSIFIER_RULES file is configured
+var defaultClassifierRules = []classifierRule{
+	{Name: "link spam", MaxLinks: intPtr(4), Verdict: verdictQuarantine, Reason: "Too many links"},
+	{Name: "mention spam", MaxMentions: intPtr(10), Verdict: verdictQuarantine, Reason: "Too many mentions"},
+}
+
+// rulesClassifier is the in-process rules engine
+type rulesClassifier struct {
+	rules []classifierRule
+}
+
+// loadClassifierRules reads a JSON array of rules, an empty path gives the default rules
+func loadClassifierRules(path string) (*rulesClassifier, error) {
+	rules := defaultClassifierRules
+	if path != "" {
+		dat, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rules = nil
+		if err := json.Unmarshal(dat, &rules); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, rule := range rules {
+		if rule.Verdict != verdictReject && rule.Verdict != verdictQuarantine {
+			return nil, fmt.Errorf("rule %q: verdict must be reject or quarantine", rule.Name)
+		}
+		if rule.Pattern == "" && rule.MaxLinks == nil && rule.MaxMentions == nil {
+			return nil, fmt.Errorf("rule %q: needs a pattern, max_links or max_mentions", rule.Name)
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			rules[i].pattern = pattern
+		}
+	}
+
+	return &rulesClassifier{rules: rules}, nil
+}
+
+func (c *rulesClassifier) classify(ctx context.Context, author User, body string) (classification, error) {
+	links := len(urlPattern.FindAllStringIndex(body, -1))
+	mentions := len(parseMentions(body))
+
+	result := classification{Verdict: verdictAllow}
+	for _, rule := range c.rules {
+		matched := (rule.pattern == nil || rule.pattern.MatchString(body)) &&
+			(rule.MaxLinks == nil || links > *rule.MaxLinks) &&
+			(rule.MaxMentions == nil || mentions > *rule.MaxMentions)
+		if matched && verdictSeverity(rule.Verdict) > verdictSeverity(result.Verdict) {
+			result = classification{Verdict: rule.Verdict, Reason: rule.Reason}
+		}
+	}
+
+	return result, nil
+}
+
+// httpClassifier asks an outside service, it is sent the author and body as JSON and answers with a classification
+type httpClassifier struct {
+	url    string
+	client *http.Client
+}
+
+func (c *httpClassifier) classify(ctx context.Context, author User, body string) (classification, error) {
+	payload, err := json.Marshal(struct {
+		AuthorId int    `json:"author_id"`
+		Red      bool   `json:"is_chirpy_red"`
+		Body     string `json:"body"`
+	}{author.Id, author.Red, body})
+	if err != nil {
+		return classification{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(payload))
+	if err != nil {
+		return classification{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return classification{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return classification{}, fmt.Errorf("classifier answered with status %d", resp.StatusCode)
+	}
+
+	var result classification
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return classification{}, err
+	}
+	if result.Verdict != verdictAllow && result.Verdict != verdictReject && result.Verdict != verdictQuarantine {
+		return classification{}, fmt.Errorf("classifier answered with unknown verdict %q", result.Verdict)
+	}
+
+	return result, nil
+}
+
+// classifyChirp runs the configured classifiers within the timeout. When one of them fails the verdicts of
+// the others still apply, unless CLASSIFIER_FAIL_CLOSED is set, then the chirp is refused with
+// errClassifierUnavailable if it was not rejected anyway
+func (cfg *apiConfig) classifyChirp(ctx context.Context, author User, body string) (classification, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.classifierTimeout)
+	defer cancel()
+
+	result, err := cfg.classifier.classify(ctx, author, body)
+	if result.Verdict == "" {
+		result.Verdict = verdictAllow
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to classify chirp: %s\n", err)
+		if cfg.classifierFailClosed && result.Verdict != verdictReject {
+			return classification{}, errClassifierUnavailable
+		}
+	}
+
+	return result, nil
+}
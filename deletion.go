@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// scheduleDeletion deactivates an account right away and leaves the permanent purge to purgeDeletedUsers,
+// without a grace period the account is purged immediately
+func (cfg *apiConfig) scheduleDeletion(user User, anonymizeChirps bool) (time.Time, error) {
+	now := time.Now().UTC()
+	_, err := cfg.database.updateUser(user.Id, func(u *User) error {
+		u.DeletedAt = &now
+		u.AnonymizeChirps = anonymizeChirps
+		revokeSessions(u)
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	cfg.removeUserExports(user.Id)
+
+	if cfg.deletionGrace <= 0 {
+		return now, cfg.database.purgeUser(user.Id, anonymizeChirps)
+	}
+
+	return now.Add(cfg.deletionGrace), nil
+}
+
+// purgeDeletedUsers permanently removes every account whose grace period has run out
+func (cfg *apiConfig) purgeDeletedUsers() {
+	now := time.Now().UTC()
+
+	for _, user := range cfg.database.listUsers() {
+		if user.DeletedAt == nil || user.DeletedAt.Add(cfg.deletionGrace).After(now) {
+			continue
+		}
+
+		if err := cfg.database.purgeUser(user.Id, user.AnonymizeChirps); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to purge user %d: %s\n", user.Id, err)
+			continue
+		}
+		cfg.removeProfileImages(user)
+	}
+}
+
+type deletionResponse struct {
+	Id      int       `json:"id"`
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+func (cfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Password        string `json:"password"`
+		AnonymizeChirps bool   `json:"anonymize_chirps"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var params parameters
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	// A stolen access token alone must not be enough to wipe an account
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)); err != nil {
+		respondWithError(w, 401, "Password is incorrect")
+		return
+	}
+
+	purgeAt, err := cfg.scheduleDeletion(user, params.AnonymizeChirps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to delete user: %s\n", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 202, deletionResponse{user.Id, purgeAt})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func (cfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok || !cfg.canView(&user, chirp) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	if chirp.AuthorId != user.Id {
+		respondWithError(w, 403, "Only the author can edit a chirp")
+		return
+	}
+
+	if chirp.RechirpOf != nil {
+		respondWithError(w, 400, "Rechirps have no text to edit")
+		return
+	}
+
+	type parameters struct {
+		Body *string `json:"body"`
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxChirpRequestBytes)
+	decoder := json.NewDecoder(r.Body)
+	var params parameters
+	err = decoder.Decode(&params)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, 413, errChirpTooLarge.Error())
+		return
+	}
+	if err != nil || params.Body == nil {
+		respondWithError(w, 400, "body must be given")
+		return
+	}
+
+	body, flagged, err := cfg.cleanChirpBody(user, *params.Body)
+	if err != nil {
+		respondWithChirpBodyError(w, err)
+		return
+	}
+
+	// Saving the same body again is not an edit and should not show up in the history
+	if body != chirp.Body {
+		verdict, err := cfg.classifyChirp(r.Context(), user, body)
+		if err != nil {
+			respondWithError(w, 503, err.Error())
+			return
+		}
+		if verdict.Verdict == verdictReject {
+			respondWithError(w, 400, "Chirp was rejected: "+verdict.Reason)
+			return
+		}
+
+		quarantined := verdict.Verdict == verdictQuarantine
+		previouslyMentioned := chirp.MentionIds
+		edited := chirp
+		edited.Body = body
+		edited.MentionIds = cfg.resolveMentions(user, body)
+		edited.Flagged = flagged || quarantined
+		edited.Hidden = quarantined
+		chirp, err = cfg.database.editChirp(edited)
+		if err != nil {
+			respondWithError(w, 500, "Failed to store chirp in database")
+			return
+		}
+		if quarantined {
+			decision := ModerationDecision{
+				ChirpId:   chirp.Id,
+				AuthorId:  chirp.AuthorId,
+				Action:    moderationQuarantine,
+				Note:      verdict.Reason,
+				CreatedAt: time.Now().UTC(),
+			}
+			if _, err := cfg.database.storeModerationDecision(decision, false); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to store moderation decision: %s\n", err)
+			}
+		}
+		cfg.notifyMentions(chirp, previouslyMentioned)
+	}
+
+	respondWithJSON(w, 200, cfg.newChirpResponse(&user, chirp))
+}
+
+func (cfg *apiConfig) handlerGetChirpHistory(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok || !cfg.canView(cfg.optionalViewer(r), chirp) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	// The current body is the latest version, listed after every previous one
+	versions := cfg.database.listChirpVersions(chirp.Id)
+	current := ChirpVersion{ChirpId: chirp.Id, Version: len(versions) + 1, Body: chirp.Body, CreatedAt: chirp.CreatedAt}
+	if chirp.EditedAt != nil {
+		current.CreatedAt = *chirp.EditedAt
+	}
+	versions = append(versions, current)
+
+	respondWithJSON(w, 200, versions)
+}
+
+type ChirpVersion struct {
+	ChirpId   int       `json:"chirp_id"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
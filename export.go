@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+
+	defaultExportLinkTTL = 24 * time.Hour
+)
+
+var exportIndexTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Your Chirpy data</title>
+  </head>
+  <body>
+    <h1>Your Chirpy data</h1>
+    <p>Exported {{.ExportedAt.Format "2006-01-02 15:04 MST"}}</p>
+    <h2>Account</h2>
+    <ul>
+      <li>Id: {{.Profile.Id}}</li>
+      <li>Email: {{.Profile.Email}}</li>
+      <li>Role: {{.Profile.Role}}</li>
+      <li>Chirpy Red: {{.Profile.Red}}</li>
+    </ul>
+    <h2>Chirps ({{len .Chirps}})</h2>
+    <ol>
+    {{- range .Chirps}}
+      <li>#{{.Id}}: {{.Body}}</li>
+    {{- end}}
+    </ol>
+  </body>
+</html>
+`))
+
+// exportProfile is everything we hold about an account, minus the password hash and token secrets
+type exportProfile struct {
+	Id        int    `json:"id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Red       bool   `json:"is_chirpy_red"`
+	Suspended bool   `json:"is_suspended"`
+}
+
+type exportArchive struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Profile    exportProfile `json:"profile"`
+	Chirps     []Chirp       `json:"chirps"`
+}
+
+type exportResponse struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	IncludeHtml bool       `json:"include_html"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadUrl string     `json:"download_url,omitempty"`
+}
+
+func newExportResponse(e DataExport) exportResponse {
+	resp := exportResponse{
+		Id:          e.Id,
+		Status:      e.Status,
+		IncludeHtml: e.IncludeHtml,
+		CreatedAt:   e.CreatedAt,
+	}
+
+	if e.Status == exportReady {
+		resp.ExpiresAt = e.ExpiresAt
+		resp.DownloadUrl = "/api/exports/download/" + e.DownloadToken
+	}
+
+	return resp
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	read, err := rand.Read(b)
+	if err != nil || read < n {
+		return "", errors.New("failed to get random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// buildExport assembles the archive for an export in the background, the export record tracks its progress.
+// An export removed while it was being built, because the account was deleted, does not come back
+func (cfg *apiConfig) buildExport(export DataExport) {
+	err := cfg.writeExportArchive(export)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build export %s: %s\n", export.Id, err)
+		removeExportFile(export)
+		export.Status = exportFailed
+		cfg.database.updateExport(export)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(cfg.exportLinkTTL)
+	export.Status = exportReady
+	export.ExpiresAt = &expiresAt
+	if !cfg.database.updateExport(export) {
+		removeExportFile(export)
+	}
+}
+
+func (cfg *apiConfig) writeExportArchive(export DataExport) error {
+	user, ok := cfg.database.getUser(export.UserId)
+	if !ok {
+		return errUnknownUser
+	}
+
+	archive := exportArchive{
+		ExportedAt: time.Now().UTC(),
+		Profile:    exportProfile{user.Id, user.Email, newAdminUserResponse(user).Role, user.Red, user.Suspended},
+		Chirps:     []Chirp{},
+	}
+	for _, chirp := range cfg.database.listChirps() {
+		if chirp.AuthorId == user.Id {
+			archive.Chirps = append(archive.Chirps, chirp)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.exportDir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(export.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	dataWriter, err := zw.Create("chirpy.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(dataWriter)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+
+	if export.IncludeHtml {
+		htmlWriter, err := zw.Create("index.html")
+		if err != nil {
+			return err
+		}
+		if err := exportIndexTemplate.Execute(htmlWriter, archive); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func removeExportFile(export DataExport) bool {
+	if err := os.Remove(export.File); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Failed to remove export %s: %s\n", export.Id, err)
+		return false
+	}
+
+	return true
+}
+
+func (cfg *apiConfig) removeExport(export DataExport) {
+	if removeExportFile(export) {
+		cfg.database.deleteExport(export.Id)
+	}
+}
+
+// purgeExpiredExports removes archives whose download link has run out, failed exports and builds that never
+// finished are dropped once the link would have run out had they succeeded
+func (cfg *apiConfig) purgeExpiredExports() {
+	now := time.Now().UTC()
+
+	for _, export := range cfg.database.listExports() {
+		expiresAt := export.CreatedAt.Add(cfg.exportLinkTTL)
+		if export.ExpiresAt != nil {
+			expiresAt = *export.ExpiresAt
+		}
+		if !expiresAt.After(now) {
+			cfg.removeExport(export)
+		}
+	}
+}
+
+// removeUserExports drops every export of a user, a build still running throws its archive away when it finishes
+func (cfg *apiConfig) removeUserExports(userId int) {
+	for _, export := range cfg.database.listExports() {
+		if export.UserId == userId {
+			cfg.removeExport(export)
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerCreateExport(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		IncludeHtml bool `json:"include_html"`
+	}
+
+	// Every parameter is optional, so an empty body is fine
+	var params parameters
+	json.NewDecoder(r.Body).Decode(&params)
+
+	// Only one export is built at a time per user
+	for _, export := range cfg.database.listExports() {
+		if export.UserId == user.Id && export.Status == exportPending {
+			respondWithJSON(w, 202, newExportResponse(export))
+			return
+		}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	downloadToken, err := randomHex(32)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	export := DataExport{
+		Id:            id,
+		UserId:        user.Id,
+		Status:        exportPending,
+		IncludeHtml:   params.IncludeHtml,
+		DownloadToken: downloadToken,
+		File:          filepath.Join(cfg.exportDir, id+".zip"),
+		CreatedAt:     time.Now().UTC(),
+	}
+	export, err = cfg.database.storeExport(export)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store export in database")
+		return
+	}
+
+	go cfg.buildExport(export)
+
+	respondWithJSON(w, 202, newExportResponse(export))
+}
+
+func (cfg *apiConfig) handlerGetExport(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	export, ok := cfg.database.getExport(r.PathValue("exportID"))
+	if !ok || export.UserId != user.Id {
+		respondWithError(w, 404, "Export does not exist")
+		return
+	}
+
+	respondWithJSON(w, 200, newExportResponse(export))
+}
+
+// handlerDownloadExport serves the archive to whoever holds the link, the token in it is the credential
+func (cfg *apiConfig) handlerDownloadExport(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+
+	var export *DataExport
+	for _, exportInDb := range cfg.database.listExports() {
+		if exportInDb.Status == exportReady && exportInDb.DownloadToken == token {
+			export = &exportInDb
+		}
+	}
+
+	if export == nil || export.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithError(w, 404, "Export does not exist or has expired")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(w, r, export.File)
+}
+
+type DataExport struct {
+	Id            string     `json:"id"`
+	UserId        int        `json:"user_id"`
+	Status        string     `json:"status"`
+	IncludeHtml   bool       `json:"include_html"`
+	DownloadToken string     `json:"download_token"`
+	File          string     `json:"file"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
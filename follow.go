@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// parsePagination reads the limit and offset query parameters shared by every paginated list
+func parsePagination(r *http.Request) (limit int, offset int, ok bool) {
+	limit = defaultPageSize
+	if queryLimit := r.URL.Query().Get("limit"); queryLimit != "" {
+		l, err := strconv.Atoi(queryLimit)
+		if err != nil || l < 1 {
+			return 0, 0, false
+		}
+		limit = min(l, maxPageSize)
+	}
+
+	if queryOffset := r.URL.Query().Get("offset"); queryOffset != "" {
+		o, err := strconv.Atoi(queryOffset)
+		if err != nil || o < 0 {
+			return 0, 0, false
+		}
+		offset = o
+	}
+
+	return limit, offset, true
+}
+
+// followTarget resolves the {userID} path value to an account that can be followed
+func (cfg *apiConfig) followTarget(w http.ResponseWriter, r *http.Request) (User, bool) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return User{}, false
+	}
+
+	user, ok := cfg.database.getUser(userID)
+	if !ok || user.DeletedAt != nil {
+		respondWithError(w, 404, "User does not exist")
+		return User{}, false
+	}
+
+	return user, true
+}
+
+// profileTarget resolves the {userID} path value to an account whose profile viewer may look at, like
+// handlerGetUser users who blocked the viewer are not found
+func (cfg *apiConfig) profileTarget(w http.ResponseWriter, r *http.Request, viewer *User) (User, bool) {
+	user, ok := cfg.followTarget(w, r)
+	if !ok {
+		return User{}, false
+	}
+
+	if viewer != nil && cfg.database.hasBlocked(user.Id, viewer.Id) {
+		respondWithError(w, 404, "User does not exist")
+		return User{}, false
+	}
+
+	return user, true
+}
+
+func (cfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	target, ok := cfg.followTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if target.Id == user.Id {
+		respondWithError(w, 400, "You cannot follow yourself")
+		return
+	}
+
+	// Someone who blocked you looks the same as someone who does not exist
+	if cfg.database.hasBlocked(target.Id, user.Id) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if cfg.database.hasBlocked(user.Id, target.Id) {
+		respondWithError(w, 400, "You cannot follow a user you blocked")
+		return
+	}
+
+	created, err := cfg.database.storeFollow(user.Id, target.Id)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store follow in database")
+		return
+	}
+	if created {
+		cfg.notify(target.Id, notificationFollow, user.Id, nil)
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	target, ok := cfg.followTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.database.deleteFollow(user.Id, target.Id); err != nil {
+		respondWithError(w, 500, "Failed to delete follow from database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// listFollowUsers serves both the followers and the following lists of a user
+func (cfg *apiConfig) listFollowUsers(w http.ResponseWriter, r *http.Request, ofFollowers bool) {
+	target, ok := cfg.profileTarget(w, r, cfg.optionalViewer(r))
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	type followResponse struct {
+		userProfile
+		FollowedAt time.Time `json:"followed_at"`
+	}
+
+	users := []followResponse{}
+	skipped := 0
+	for _, follow := range cfg.database.listFollows(target.Id, ofFollowers) {
+		otherId := follow.FollowerId
+		if !ofFollowers {
+			otherId = follow.FolloweeId
+		}
+
+		other, ok := cfg.database.getUser(otherId)
+		if !ok || other.DeletedAt != nil {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		users = append(users, followResponse{cfg.newUserProfile(other), follow.CreatedAt})
+		if len(users) == limit {
+			break
+		}
+	}
+
+	respondWithJSON(w, 200, users)
+}
+
+func (cfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollowUsers(w, r, true)
+}
+
+func (cfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollowUsers(w, r, false)
+}
+
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+type hashtagEntity struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// chirpEntities are the structured parts found in a chirp body, offsets count unicode code points
+type chirpEntities struct {
+	Hashtags []hashtagEntity `json:"hashtags"`
+	Mentions []mentionEntity `json:"mentions"`
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+// parseHashtags finds every #tag in a chirp body, a tag has to start a word and contain at least one letter
+// so things like "issue#3" or "#1" are left alone
+func parseHashtags(body string) []hashtagEntity {
+	runes := []rune(body)
+	tags := []hashtagEntity{}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '＃' {
+			continue
+		}
+		if i > 0 && (isTagRune(runes[i-1]) || runes[i-1] == '#' || runes[i-1] == '&') {
+			continue
+		}
+
+		end := i + 1
+		hasLetter := false
+		for end < len(runes) && isTagRune(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+
+		if hasLetter {
+			tags = append(tags, hashtagEntity{Tag: string(runes[i+1 : end]), Start: i, End: end})
+		}
+		i = end - 1
+	}
+
+	return tags
+}
+
+// normalizeHashtag is the form tags are indexed and looked up by, so #Go and #go are the same tag
+func normalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimLeft(tag, "#＃"))
+}
+
+// chirpHashtags lists the distinct normalized tags of a chirp body
+func chirpHashtags(body string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, entity := range parseHashtags(body) {
+		tag := normalizeHashtag(entity.Tag)
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+func (cfg *apiConfig) handlerGetHashtagChirps(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeHashtag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, 400, "Hashtag must not be empty")
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	viewer := cfg.optionalViewer(r)
+	chirps := []chirpResponse{}
+	skipped := 0
+	ids := cfg.database.listHashtagChirps(tag)
+	for i := len(ids) - 1; i >= 0 && len(chirps) < limit; i-- {
+		chirp, ok := cfg.database.getChirp(ids[i])
+		if !ok || !chirp.listed() || !cfg.inFeed(viewer, chirp) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		chirps = append(chirps, cfg.newChirpResponse(viewer, chirp))
+	}
+
+	respondWithJSON(w, 200, chirps)
+}
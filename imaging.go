@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// maxImagePixels bounds what is decoded, a few kilobytes of compressed image can claim to be enormous
+	maxImagePixels = 24_000_000
+	// maxGifFrames bounds the frames of an animation, their pixels together are bound by maxImagePixels
+	maxGifFrames = 1000
+	jpegQuality  = 90
+)
+
+var (
+	errUnsupportedImage = errors.New("Only JPEG, PNG and GIF images are supported")
+	errInvalidImage     = errors.New("Image could not be read")
+	errImageDimensions  = errors.New("Image dimensions are too large")
+)
+
+// imageFormats maps the sniffed content types we accept to the name image.DecodeConfig gives the format
+// and the extension blobs are stored with
+var imageFormats = map[string]struct{ name, ext string }{
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/png":  {"png", ".png"},
+	"image/gif":  {"gif", ".gif"},
+}
+
+// sniffImage checks what an upload really is rather than trusting the name or header it came with
+func sniffImage(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	format, ok := imageFormats[contentType]
+	if !ok {
+		return "", errUnsupportedImage
+	}
+
+	config, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || name != format.name {
+		return "", errInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return "", errImageDimensions
+	}
+
+	// DecodeConfig only looks at the canvas, every frame of an animation is decoded though
+	if contentType == "image/gif" {
+		frames, pixels, ok := gifFrames(data)
+		if !ok {
+			return "", errInvalidImage
+		}
+		if frames > maxGifFrames || pixels > maxImagePixels {
+			return "", errImageDimensions
+		}
+	}
+
+	return contentType, nil
+}
+
+// gifFrames counts the frames of a GIF and the pixels they cover by walking its blocks without decoding any
+func gifFrames(data []byte) (frames, pixels int, ok bool) {
+	// skipSubBlocks skips data sub-blocks starting at i up to and including the terminating empty one
+	skipSubBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+	colorTableSize := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+
+	// Header and logical screen descriptor, followed by the global color table
+	if len(data) < 13 {
+		return 0, 0, false
+	}
+	i := 13 + colorTableSize(data[10])
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, then sub-blocks
+			i = skipSubBlocks(i + 2)
+		case 0x2C: // image descriptor: position, size and flags, then the local color table and the LZW data
+			if i+10 > len(data) {
+				return 0, 0, false
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			frames++
+			pixels += width * height
+			i = skipSubBlocks(i + 10 + colorTableSize(data[i+9]) + 1)
+		case 0x3B: // trailer
+			return frames, pixels, true
+		default:
+			return 0, 0, false
+		}
+	}
+
+	return 0, 0, false
+}
+
+// decodeImage decodes an upload sniffed by sniffImage, JPEGs are turned upright according to their EXIF
+// orientation since re-encoding drops the EXIF data that would otherwise tell viewers to do so
+func decodeImage(data []byte, contentType string) (*image.RGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errInvalidImage
+	}
+
+	rgba := toRGBA(img)
+	if contentType == "image/jpeg" {
+		rgba = orientImage(rgba, jpegOrientation(data))
+	}
+
+	return rgba, nil
+}
+
+// encodeImage writes img in the given format, nothing but the pixels makes it into the output
+func encodeImage(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// reencodeGif re-encodes every frame of an animated GIF, dropping comments and application extensions
+// other than the loop count
+func reencodeGif(data []byte) ([]byte, *image.RGBA, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) == 0 {
+		return nil, nil, errInvalidImage
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, nil, err
+	}
+
+	// The first frame drawn on the full canvas stands in for the animation, e.g. for thumbnails
+	first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(first, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+
+	return buf.Bytes(), first, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+
+	return rgba
+}
+
+// fitWithin scales width and height down to fit a size by size box keeping the aspect ratio, never up
+func fitWithin(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
+
+// resizeImage scales src to exactly width by height, averaging the source pixels that fall into each
+// destination pixel. Enlarging repeats pixels
+func resizeImage(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		sy0 := y * sh / height
+		sy1 := max((y+1)*sh/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := x * sw / width
+			sx1 := max((x+1)*sw/width, sx0+1)
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (sy1 - sy0) * (sx1 - sx0)
+			i := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// cropToAspect cuts the largest centered region with the aspect ratio width:height out of src
+func cropToAspect(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	cw, ch := sw, sw*height/width
+	if ch > sh {
+		cw, ch = sh*width/height, sh
+	}
+	cw, ch = max(cw, 1), max(ch, 1)
+
+	x0, y0 := (sw-cw)/2, (sh-ch)/2
+	return src.SubImage(image.Rect(x0, y0, x0+cw, y0+ch)).(*image.RGBA)
+}
+
+// jpegOrientation reads the EXIF orientation tag of a JPEG, 1 (upright) when there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// Metadata segments all come before the start of scan
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
+
+// orientImage applies an EXIF orientation, 2 to 8 being the mirrorings and quarter turns of an upright image
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+
+	return dst
+}
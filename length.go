@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"unicode"
+)
+
+const (
+	defaultChirpLimit    = 140
+	defaultRedChirpLimit = 280
+	// urlWeight is what every link counts as, however long it is
+	urlWeight = 23
+	// maxClusterRunes is the most runes one character is made of, enough for the longest emoji sequences.
+	// Anything longer starts counting as another character, so piling up marks does not get around the limit
+	maxClusterRunes = 10
+	// maxChirpBytes caps a chirp however it counts against the limit, e.g. when it is mostly one long link
+	maxChirpBytes = 8 << 10
+	// maxChirpRequestBytes caps the request creating or editing a chirp, leaving room for JSON escapes
+	maxChirpRequestBytes = 64 << 10
+)
+
+var errChirpTooLarge = fmt.Errorf("Chirp is too large, it can be at most %d bytes", maxChirpBytes)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// chirpTooLongError reports the limit that was applied, which depends on the author
+type chirpTooLongError struct {
+	Length int
+	Limit  int
+}
+
+func (e chirpTooLongError) Error() string {
+	return fmt.Sprintf("Chirp is too long, it is %d characters and the limit is %d", e.Length, e.Limit)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isPictographic approximates Extended_Pictographic of UAX #29, the emoji a zero width joiner can glue
+// onto the one before it
+func isPictographic(r rune) bool {
+	switch {
+	case isRegionalIndicator(r), r >= 0x1F3FB && r <= 0x1F3FF: // flag letters and skin tones are not
+		return false
+	case r >= 0x1F000 && r <= 0x1FAFF, r >= 0x1FC00 && r <= 0x1FFFD:
+		return true
+	case r >= 0x2300 && r <= 0x23FF, r >= 0x2600 && r <= 0x27BF, r >= 0x2B00 && r <= 0x2BFF:
+		return true
+	case r == 0x00A9, r == 0x00AE, r == 0x203C, r == 0x2049, r == 0x2122, r == 0x2139, r >= 0x2194 && r <= 0x21AA,
+		r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+		return true
+	}
+
+	return false
+}
+
+// extendsCluster reports whether r belongs to the grapheme cluster before it rather than starting one,
+// a close approximation of the extend rules of UAX #29 that covers accents, emoji sequences and Hangul
+func extendsCluster(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == 0x200D: // zero width joiner
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0100 && r <= 0xE01EF: // variation selectors
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF: // emoji skin tone modifiers
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // tags, as used in subdivision flags
+		return true
+	case r >= 0x1160 && r <= 0x11FF, r >= 0xD7B0 && r <= 0xD7FF: // Hangul vowel and trailing jamo
+		return true
+	}
+
+	return false
+}
+
+// graphemeCount counts user-perceived characters, so an emoji with a skin tone, a flag or an accented
+// letter written with a combining mark all count as one. No character is longer than maxClusterRunes
+func graphemeCount(s string) int {
+	count := 0
+	var prev rune
+	// Regional indicators pair up into flags, so every second one in a row joins the one before it
+	regionalRun := 0
+	clusterRunes := 0
+	emoji := false
+
+	for i, r := range s {
+		joins := i > 0 && clusterRunes < maxClusterRunes && ((prev == '\r' && r == '\n') ||
+			(emoji && prev == 0x200D && isPictographic(r)) || // a zero width joiner glues emoji together
+			extendsCluster(r) ||
+			(isRegionalIndicator(r) && regionalRun%2 == 1))
+
+		if isRegionalIndicator(r) {
+			regionalRun++
+		} else {
+			regionalRun = 0
+		}
+		if joins {
+			clusterRunes++
+		} else {
+			count++
+			clusterRunes = 1
+			emoji = isPictographic(r)
+		}
+		prev = r
+	}
+
+	return count
+}
+
+// chirpLength is the length of a chirp as counted against the limit, links count as urlWeight
+func chirpLength(body string) int {
+	length := 0
+	last := 0
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		length += graphemeCount(body[last:loc[0]]) + urlWeight
+		last = loc[1]
+	}
+
+	return length + graphemeCount(body[last:])
+}
+
+// respondWithChirpBodyError reports a body cleanChirpBody refused, a body that is too long also gets the
+// numbers behind it so clients can show them
+func respondWithChirpBodyError(w http.ResponseWriter, err error) {
+	var tooLong chirpTooLongError
+	if errors.As(err, &tooLong) {
+		respondWithJSON(w, 400, struct {
+			Error  string `json:"error"`
+			Length int    `json:"length"`
+			Limit  int    `json:"limit"`
+		}{err.Error(), tooLong.Length, tooLong.Limit})
+		return
+	}
+
+	respondWithError(w, 400, err.Error())
+}
+
+// chirpLimit is how long chirps by user may be, Chirpy Red members get more room
+func (cfg *apiConfig) chirpLimit(user User) int {
+	if user.Red {
+		return cfg.maxRedChirpLength
+	}
+
+	return cfg.maxChirpLength
+}
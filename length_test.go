@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGraphemeCount(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"precomposed accent", "café", 4},
+		{"combining accent", "cafe\u0301", 4},
+		{"stacked combining marks", "a\u0323\u0301\u0308", 1},
+		{"skin tone", "\U0001F44D\U0001F3FD", 1},
+		{"zwj family", "\U0001F468\u200D\U0001F469\u200D\U0001F467\u200D\U0001F466", 1},
+		{"zwj with skin tone", "\U0001F469\U0001F3FD\u200D\U0001F4BB", 1},
+		{"variation selector", "\u2764\uFE0F", 1},
+		{"keycap", "1\uFE0F\u20E3", 1},
+		{"two flags", "\U0001F1F3\U0001F1F4\U0001F1F8\U0001F1EA", 2},
+		{"flag and a lone regional indicator", "\U0001F1F3\U0001F1F4\U0001F1F8", 2},
+		{"flags split by text", "\U0001F1F3 \U0001F1F4", 3},
+		{"subdivision flag", "\U0001F3F4\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F", 1},
+		{"precomposed hangul", "한국어", 3},
+		{"hangul jamo", "\u1100\u1161\u11A8", 1},
+		{"crlf", "a\r\nb", 3},
+		{"mixed", "hi \U0001F44B\U0001F3FB cafe\u0301!", 10},
+		{"kiss with skin tones", "\U0001F469\U0001F3FB\u200D\u2764\uFE0F\u200D\U0001F48B\u200D\U0001F468\U0001F3FC", 1},
+		{"zwj before a letter", strings.Repeat("a\u200D", 500), 500},
+		{"zwj after a letter", "a\u200D\U0001F44D", 2},
+		{"piled up marks", "a" + strings.Repeat("\u0301", 5000), 501}, // a with 9 marks, then 500 clusters of 10 marks
+		{"chained emoji", strings.Repeat("\U0001F44D\u200D", 100), 200 / maxClusterRunes},
+	}
+
+	for _, tt := range tests {
+		if got := graphemeCount(tt.s); got != tt.want {
+			t.Errorf("%s: graphemeCount(%q) = %d, want %d", tt.name, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestChirpLength(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"plain text", "hello world", 11},
+		{"short link", "see http://a.co", 4 + urlWeight},
+		{"long link", "see https://example.com/" + strings.Repeat("a", 200), 4 + urlWeight},
+		{"two links", "https://a.example http://b.example", urlWeight + 1 + urlWeight},
+		{"link between emoji", "\U0001F44D\U0001F3FD https://example.com \U0001F1F3\U0001F1F4", 1 + 1 + urlWeight + 1 + 1},
+		{"not a link", "example.com and ftp://example.com", 33},
+		{"at the limit", strings.Repeat("x", defaultChirpLimit), defaultChirpLimit},
+		{"zwj chain", strings.Repeat("a\u200D", 500), 500},
+	}
+
+	for _, tt := range tests {
+		if got := chirpLength(tt.body); got != tt.want {
+			t.Errorf("%s: chirpLength(%q) = %d, want %d", tt.name, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCleanChirpBodySize(t *testing.T) {
+	cfg := &apiConfig{maxChirpLength: defaultChirpLimit, profanity: newTestProfanityFilter(t, profanityMask)}
+
+	// A huge link counts as urlWeight, the byte cap is what stops it
+	body := "see https://example.com/" + strings.Repeat("a", 100<<10)
+	if _, _, err := cfg.cleanChirpBody(User{}, body); !errors.Is(err, errChirpTooLarge) {
+		t.Errorf("cleanChirpBody of a %d byte link = %v, want %v", len(body), err, errChirpTooLarge)
+	}
+
+	var tooLong chirpTooLongError
+	if _, _, err := cfg.cleanChirpBody(User{}, "a"+strings.Repeat("\u0301", 2000)); !errors.As(err, &tooLong) {
+		t.Errorf("cleanChirpBody of piled up marks = %v, want a chirpTooLongError", err)
+	}
+
+	if got, _, err := cfg.cleanChirpBody(User{}, "hello"); err != nil || got != "hello" {
+		t.Errorf("cleanChirpBody(%q) = %q, %v, want it unchanged", "hello", got, err)
+	}
+}
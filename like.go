@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// likeTarget resolves the {chirpID} path value to a chirp the user is able to see
+func (cfg *apiConfig) likeTarget(w http.ResponseWriter, r *http.Request, user User) (Chirp, bool) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return Chirp{}, false
+	}
+
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok || !cfg.canView(&user, chirp) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return Chirp{}, false
+	}
+
+	return chirp, true
+}
+
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirp, ok := cfg.likeTarget(w, r, user)
+	if !ok {
+		return
+	}
+
+	originalId := chirp.originalId()
+	created, err := cfg.database.storeLike(user.Id, originalId)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store like in database")
+		return
+	}
+	if original, ok := cfg.database.getChirp(originalId); ok && created {
+		cfg.notify(original.AuthorId, notificationLike, user.Id, &originalId)
+	}
+
+	w.WriteHeader(204)
+}
+
+// handlerUnlikeChirp skips the visibility check of likeTarget, a like stays removable after the chirp
+// has gone out of sight through a block, its visibility or a moderator hiding it
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	if err := cfg.database.deleteLike(user.Id, chirp.originalId()); err != nil {
+		respondWithError(w, 500, "Failed to delete like from database")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerGetUserLikes(w http.ResponseWriter, r *http.Request) {
+	viewer := cfg.optionalViewer(r)
+	target, ok := cfg.profileTarget(w, r, viewer)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	type likedChirpResponse struct {
+		chirpResponse
+		LikedAt time.Time `json:"liked_at"`
+	}
+
+	chirps := []likedChirpResponse{}
+	skipped := 0
+	for _, like := range cfg.database.listUserLikes(target.Id) {
+		chirp, ok := cfg.database.getChirp(like.ChirpId)
+		if !ok || !cfg.canView(viewer, chirp) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		chirps = append(chirps, likedChirpResponse{cfg.newChirpResponse(viewer, chirp), like.CreatedAt})
+		if len(chirps) == limit {
+			break
+		}
+	}
+
+	respondWithJSON(w, 200, chirps)
+}
+
+type Like struct {
+	UserId    int       `json:"user_id"`
+	ChirpId   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -5,6 +5,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,21 +20,168 @@ type apiConfig struct {
 	database       Database
 	jwtSecret      string
 	polkaKey       string
+	adminEmail     string
+	deletionGrace  time.Duration
+	exportDir      string
+	exportLinkTTL  time.Duration
+	blobs          blobStore
+	maxUploadSize  int64
+	trends         *trendTracker
+	stream         *chirpStream
+	sockets        *socketHub
+	search         *searchIndex
+	profanity      *profanityFilter
+
+	maxChirpLength    int
+	maxRedChirpLength int
+
+	reportHideThreshold int
+
+	classifier           chirpClassifier
+	classifierTimeout    time.Duration
+	classifierFailClosed bool
 }
 
 func main() {
 	mux := http.NewServeMux()
 	godotenv.Load()
 	apiCfg := apiConfig{
-		database:  *FreshNewDb(),
-		jwtSecret: os.Getenv("JWT_SECRET"),
-		polkaKey:  os.Getenv("POLKA_KEY"),
+		database:   *FreshNewDb(),
+		jwtSecret:  os.Getenv("JWT_SECRET"),
+		polkaKey:   os.Getenv("POLKA_KEY"),
+		adminEmail: os.Getenv("ADMIN_EMAIL"),
+		exportDir:  "exports",
+		trends:     newTrendTracker(),
+		stream:     newChirpStream(),
+		sockets:    newSocketHub(),
+		search:     newSearchIndex(),
+	}
+
+	apiCfg.deletionGrace = defaultDeletionGrace
+	if grace := os.Getenv("ACCOUNT_DELETION_GRACE"); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("invalid ACCOUNT_DELETION_GRACE: %s\n", err)
+		}
+		apiCfg.deletionGrace = d
+	}
+
+	apiCfg.exportLinkTTL = defaultExportLinkTTL
+	if ttl := os.Getenv("EXPORT_LINK_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("invalid EXPORT_LINK_TTL: %s\n", err)
+		}
+		apiCfg.exportLinkTTL = d
+	}
+
+	mediaDir := "media"
+	if dir := os.Getenv("MEDIA_DIR"); dir != "" {
+		mediaDir = dir
+	}
+	// Whatever is in assets is served to anyone by /app/assets/, which would skip the checks of handlerServeBlob
+	assetsPath, _ := filepath.Abs("assets")
+	mediaPath, _ := filepath.Abs(mediaDir)
+	if rel, err := filepath.Rel(assetsPath, mediaPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Fatalf("invalid MEDIA_DIR: %q is inside assets\n", mediaDir)
+	}
+	blobs, err := newDiskBlobStore(mediaDir, "/media/")
+	if err != nil {
+		log.Fatalf("failed to create MEDIA_DIR: %s\n", err)
+	}
+	apiCfg.blobs = blobs
+
+	apiCfg.maxUploadSize = defaultMaxUploadSize
+	if size := os.Getenv("MEDIA_MAX_BYTES"); size != "" {
+		s, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || s < 1 {
+			log.Fatalf("invalid MEDIA_MAX_BYTES: %q\n", size)
+		}
+		apiCfg.maxUploadSize = s
+	}
+
+	apiCfg.maxChirpLength = defaultChirpLimit
+	if limit := os.Getenv("CHIRP_MAX_LENGTH"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			log.Fatalf("invalid CHIRP_MAX_LENGTH: %q\n", limit)
+		}
+		apiCfg.maxChirpLength = l
+	}
+
+	apiCfg.maxRedChirpLength = max(defaultRedChirpLimit, apiCfg.maxChirpLength)
+	if limit := os.Getenv("CHIRP_MAX_LENGTH_RED"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			log.Fatalf("invalid CHIRP_MAX_LENGTH_RED: %q\n", limit)
+		}
+		apiCfg.maxRedChirpLength = l
 	}
 
+	apiCfg.reportHideThreshold = defaultReportHideThreshold
+	if threshold := os.Getenv("REPORT_HIDE_THRESHOLD"); threshold != "" {
+		t, err := strconv.Atoi(threshold)
+		if err != nil || t < 0 {
+			log.Fatalf("invalid REPORT_HIDE_THRESHOLD: %q\n", threshold)
+		}
+		apiCfg.reportHideThreshold = t
+	}
+
+	rules, err := loadClassifierRules(os.Getenv("CLASSIFIER_RULES"))
+	if err != nil {
+		log.Fatalf("invalid CLASSIFIER_RULES: %s\n", err)
+	}
+	classifiers := classifierChain{rules}
+	if url := os.Getenv("CLASSIFIER_URL"); url != "" {
+		classifiers = append(classifiers, &httpClassifier{url: url, client: &http.Client{}})
+	}
+	apiCfg.classifier = classifiers
+
+	apiCfg.classifierTimeout = defaultClassifierTimeout
+	if timeout := os.Getenv("CLASSIFIER_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("invalid CLASSIFIER_TIMEOUT: %s\n", err)
+		}
+		apiCfg.classifierTimeout = d
+	}
+	apiCfg.classifierFailClosed = os.Getenv("CLASSIFIER_FAIL_CLOSED") == "true"
+
+	profanity, err := newProfanityFilter(os.Getenv("PROFANITY_FILE"), os.Getenv("PROFANITY_ACTION"))
+	if err != nil {
+		log.Fatalf("invalid profanity configuration: %s\n", err)
+	}
+	apiCfg.profanity = profanity
+
+	// Reload the profanity list on SIGHUP, the same as POST /admin/api/profanity/reload
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			if err := apiCfg.profanity.reload(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload profanity list: %s\n", err)
+				continue
+			}
+			fmt.Println("Reloaded profanity list")
+		}
+	}()
+
+	for _, chirp := range apiCfg.database.listChirps() {
+		apiCfg.trends.handleChirpEvent(chirpEvent{Kind: chirpCreated, Chirp: chirp})
+		apiCfg.search.handleChirpEvent(chirpEvent{Kind: chirpCreated, Chirp: chirp})
+	}
+	apiCfg.database.onChirpEvent(apiCfg.search.handleChirpEvent)
+	apiCfg.database.onChirpEvent(apiCfg.trends.handleChirpEvent)
+	apiCfg.database.onChirpEvent(apiCfg.stream.handleChirpEvent)
+	apiCfg.database.onNotification(apiCfg.sockets.handleNotification)
+
 	// Keep database up to date
 	go func() {
 		for {
 			time.Sleep(10 * time.Second)
+			apiCfg.purgeDeletedUsers()
+			apiCfg.purgeExpiredExports()
+			apiCfg.purgeAttachments()
 			fmt.Println("Syncing database...")
 			err := apiCfg.database.sync()
 			if err != nil {
@@ -39,24 +191,91 @@ func main() {
 		}
 	}()
 
+	// Only the web app itself is served, the working directory also holds the database and exports
 	mux.Handle(
-		"/app/",
-		apiCfg.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))),
+		"/app/{$}",
+		apiCfg.middlewareMetricsInc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "index.html")
+		})),
 	)
+	mux.Handle(
+		"/app/assets/",
+		apiCfg.middlewareMetricsInc(http.StripPrefix("/app/assets/", http.FileServer(http.Dir("assets")))),
+	)
+	mux.HandleFunc("GET /media/{key}", apiCfg.handlerServeBlob)
 	mux.HandleFunc("GET /api/healthz", apiCfg.handlerHealth)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerGetMetrics)
-	mux.HandleFunc("/api/reset", apiCfg.handlerResetMetrics)
+	mux.HandleFunc("POST /api/attachments", apiCfg.handlerUploadAttachment)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerEditChirp)
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", apiCfg.handlerEditChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", apiCfg.handlerGetChirpHistory)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerGetThread)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/likes", apiCfg.handlerLikeChirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/likes", apiCfg.handlerUnlikeChirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirps", apiCfg.handlerRechirp)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirps", apiCfg.handlerUndoRechirp)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/reports", apiCfg.handlerReportChirp)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	mux.HandleFunc("DELETE /api/users", apiCfg.handlerDeleteUser)
+	mux.HandleFunc("PUT /api/users/avatar", apiCfg.handlerUploadProfileImage(profileAvatar))
+	mux.HandleFunc("DELETE /api/users/avatar", apiCfg.handlerDeleteProfileImage(profileAvatar))
+	mux.HandleFunc("PUT /api/users/banner", apiCfg.handlerUploadProfileImage(profileBanner))
+	mux.HandleFunc("DELETE /api/users/banner", apiCfg.handlerDeleteProfileImage(profileBanner))
+	mux.HandleFunc("GET /api/users/{userID}", apiCfg.handlerGetUser)
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.handlerFollowUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.handlerUnfollowUser)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerGetFollowing)
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.handlerGetUserLikes)
+	mux.HandleFunc("POST /api/users/{userID}/block", apiCfg.handlerBlockUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.handlerUnblockUser)
+	mux.HandleFunc("POST /api/users/{userID}/mute", apiCfg.handlerMuteUser)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", apiCfg.handlerUnmuteUser)
+	mux.HandleFunc("GET /api/blocks", apiCfg.handlerGetBlocks)
+	mux.HandleFunc("GET /api/mutes", apiCfg.handlerGetMutes)
+	mux.HandleFunc("GET /api/timeline", apiCfg.handlerGetTimeline)
+	mux.HandleFunc("GET /api/mentions", apiCfg.handlerGetMentions)
+	mux.HandleFunc("GET /api/notifications", apiCfg.handlerGetNotifications)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.handlerReadAllNotifications)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiCfg.handlerReadNotification)
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.handlerGetNotificationPreferences)
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.handlerUpdateNotificationPreferences)
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.handlerGetHashtagChirps)
+	mux.HandleFunc("GET /api/trends", apiCfg.handlerGetTrends)
+	mux.HandleFunc("GET /api/search/chirps", apiCfg.handlerSearchChirps)
+	mux.HandleFunc("GET /api/search/users", apiCfg.handlerSearchUsers)
+	mux.HandleFunc("GET /api/stream", apiCfg.handlerStreamChirps)
+	mux.HandleFunc("GET /api/ws", apiCfg.handlerSocket)
+	mux.HandleFunc("POST /api/users/export", apiCfg.handlerCreateExport)
+	mux.HandleFunc("GET /api/exports/{exportID}", apiCfg.handlerGetExport)
+	mux.HandleFunc("GET /api/exports/download/{token}", apiCfg.handlerDownloadExport)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefreshToken)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevokeToken)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
 
+	mux.Handle("GET /admin/api/metrics", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerGetMetrics))
+	mux.Handle("POST /admin/api/reset", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerResetMetrics))
+	mux.Handle("GET /admin/api/users", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerAdminGetUsers))
+	mux.Handle("GET /admin/api/users/{userID}", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerAdminGetUser))
+	mux.Handle("PUT /admin/api/users/{userID}/role", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerUpdateUserRole))
+	mux.Handle("POST /admin/api/users/{userID}/suspend", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerSuspendUser))
+	mux.Handle("DELETE /admin/api/users/{userID}/suspend", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerUnsuspendUser))
+	mux.Handle("POST /admin/api/users/{userID}/revoke", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerRevokeUserSessions))
+	mux.Handle("PUT /admin/api/users/{userID}/red", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerUpdateUserRed))
+	mux.Handle("DELETE /admin/api/users/{userID}", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerAdminDeleteUser))
+	mux.Handle("GET /admin/api/audit", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerGetAuditLog))
+	mux.Handle("POST /admin/api/profanity/reload", apiCfg.middlewareRequireRole(roleAdmin, apiCfg.handlerReloadProfanity))
+	mux.Handle("GET /admin/api/chirps/flagged", apiCfg.middlewareRequireRole(roleModerator, apiCfg.handlerGetFlaggedChirps))
+	mux.Handle("GET /admin/api/moderation/queue", apiCfg.middlewareRequireRole(roleModerator, apiCfg.handlerGetModerationQueue))
+	mux.Handle("GET /admin/api/moderation/decisions", apiCfg.middlewareRequireRole(roleModerator, apiCfg.handlerGetModerationDecisions))
+	mux.Handle("GET /admin/api/chirps/{chirpID}/reports", apiCfg.middlewareRequireRole(roleModerator, apiCfg.handlerGetChirpReports))
+	mux.Handle("POST /admin/api/chirps/{chirpID}/moderation", apiCfg.middlewareRequireRole(roleModerator, apiCfg.handlerModerateChirp))
+
 	s := &http.Server{
 		Addr:    ":8080",
 		Handler: mux,
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const maxUsernameLength = 15
+
+var (
+	errInvalidUsername = errors.New("Username must be 1 to 15 letters, digits or underscores")
+	errUsernameTaken   = errors.New("Username is already taken")
+)
+
+func isUsernameRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+func validUsername(username string) bool {
+	if username == "" || len(username) > maxUsernameLength {
+		return false
+	}
+
+	for _, r := range username {
+		if !isUsernameRune(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type mentionEntity struct {
+	Username string `json:"username"`
+	UserId   int    `json:"user_id"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type mentionSpan struct {
+	username   string
+	start, end int
+}
+
+// parseMentions finds every @username in a chirp body, an @ in the middle of a word such as an email address is not a mention
+func parseMentions(body string) []mentionSpan {
+	runes := []rune(body)
+	mentions := []mentionSpan{}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 && (isTagRune(runes[i-1]) || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+
+		if name := string(runes[i+1 : end]); validUsername(name) {
+			mentions = append(mentions, mentionSpan{name, i, end})
+		}
+		i = end - 1
+	}
+
+	return mentions
+}
+
+// mentionEntities resolves the mentions in a chirp body against the current usernames, unknown names are left out
+func (cfg *apiConfig) mentionEntities(body string) []mentionEntity {
+	entities := []mentionEntity{}
+	for _, mention := range parseMentions(body) {
+		user, ok := cfg.database.getUserByUsername(mention.username)
+		if !ok || user.DeletedAt != nil {
+			continue
+		}
+
+		entities = append(entities, mentionEntity{user.Username, user.Id, mention.start, mention.end})
+	}
+
+	return entities
+}
+
+// resolveMentions lists the distinct users a chirp body by author mentions, the author mentioning themselves
+// included. Users on either side of a block with the author cannot be mentioned
+func (cfg *apiConfig) resolveMentions(author User, body string) []int {
+	ids := []int{}
+	for _, entity := range cfg.mentionEntities(body) {
+		if !slices.Contains(ids, entity.UserId) && !cfg.database.isBlocked(author.Id, entity.UserId) {
+			ids = append(ids, entity.UserId)
+		}
+	}
+
+	return ids
+}
+
+// chirpMentionEntities are the mention entities of a stored chirp, only the users it actually mentioned are linked
+func (cfg *apiConfig) chirpMentionEntities(chirp Chirp) []mentionEntity {
+	entities := []mentionEntity{}
+	for _, entity := range cfg.mentionEntities(chirp.Body) {
+		if slices.Contains(chirp.MentionIds, entity.UserId) {
+			entities = append(entities, entity)
+		}
+	}
+
+	return entities
+}
+
+// notifyMentions tells everyone newly mentioned in a chirp about it, except the author
+func (cfg *apiConfig) notifyMentions(chirp Chirp, previouslyMentioned []int) {
+	for _, userId := range chirp.MentionIds {
+		if userId == chirp.AuthorId || slices.Contains(previouslyMentioned, userId) {
+			continue
+		}
+
+		cfg.notify(userId, notificationMention, chirp.AuthorId, &chirp.Id)
+	}
+}
+
+func (cfg *apiConfig) handlerGetMentions(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	chirps := []chirpResponse{}
+	skipped := 0
+	ids := cfg.database.listMentionChirps(user.Id)
+	for i := len(ids) - 1; i >= 0 && len(chirps) < limit; i-- {
+		chirp, ok := cfg.database.getChirp(ids[i])
+		if !ok || !cfg.inFeed(&user, chirp) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		chirps = append(chirps, cfg.newChirpResponse(&user, chirp))
+	}
+
+	respondWithJSON(w, 200, chirps)
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
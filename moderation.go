@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultReportHideThreshold = 5
+	maxReportDetailsLength     = 500
+)
+
+var reportReasons = []string{"spam", "abuse", "harassment", "hate", "misinformation", "other"}
+
+const (
+	moderationDismiss  = "dismiss"
+	moderationHide     = "hide"
+	moderationDelete   = "delete"
+	moderationWarn     = "warn"
+	moderationSuspend  = "suspend"
+	moderationAutoHide = "auto_hide"
+)
+
+var moderationActions = []string{moderationDismiss, moderationHide, moderationDelete, moderationWarn, moderationSuspend}
+
+// notificationWarning is sent to authors warned by a moderator, it cannot be switched off so it is not in notificationTypes
+const notificationWarning = "warning"
+
+var errAlreadyReported = errors.New("You already reported this chirp")
+
+// moderationTarget resolves the {chirpID} path value for moderators, hidden chirps included
+func (cfg *apiConfig) moderationTarget(w http.ResponseWriter, r *http.Request) (Chirp, bool) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return Chirp{}, false
+	}
+
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok {
+		respondWithError(w, 404, "Chirp does not exist")
+		return Chirp{}, false
+	}
+
+	return chirp, true
+}
+
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirp, ok := cfg.likeTarget(w, r, user)
+	if !ok {
+		return
+	}
+
+	if chirp.AuthorId == user.Id {
+		respondWithError(w, 400, "You cannot report your own chirp")
+		return
+	}
+
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || !slices.Contains(reportReasons, params.Reason) {
+		respondWithError(w, 400, "reason must be one of "+strings.Join(reportReasons, ", "))
+		return
+	}
+
+	details := strings.TrimSpace(params.Details)
+	if utf8.RuneCountInString(details) > maxReportDetailsLength {
+		respondWithError(w, 400, fmt.Sprintf("details must be at most %d characters", maxReportDetailsLength))
+		return
+	}
+
+	report := Report{
+		ChirpId:    chirp.Id,
+		ReporterId: user.Id,
+		Reason:     params.Reason,
+		Details:    details,
+		CreatedAt:  time.Now().UTC(),
+	}
+	report, err = cfg.database.storeReport(report)
+	if errors.Is(err, errAlreadyReported) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to store report in database")
+		return
+	}
+
+	// Enough people reporting the same chirp hides it until a moderator had a look,
+	// the reports stay open for the moderator to settle
+	if cfg.reportHideThreshold > 0 {
+		cfg.database.autoHideChirp(chirp.Id, cfg.reportHideThreshold)
+	}
+
+	respondWithJSON(w, 201, report)
+}
+
+// handlerGetModerationQueue lists the chirps waiting for a moderator, the most reported first. Chirps the
+// profanity filter flagged are in it as well, even without reports
+func (cfg *apiConfig) handlerGetModerationQueue(w http.ResponseWriter, r *http.Request) {
+	moderator, _ := userFromContext(r.Context())
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	type queueEntry struct {
+		Chirp          chirpResponse  `json:"chirp"`
+		ReportCount    int            `json:"report_count"`
+		Reasons        map[string]int `json:"reasons"`
+		LastReportedAt *time.Time     `json:"last_reported_at"`
+	}
+
+	entries := map[int]*queueEntry{}
+	for _, report := range cfg.database.listReports() {
+		if report.DecisionId != nil {
+			continue
+		}
+
+		entry, ok := entries[report.ChirpId]
+		if !ok {
+			chirp, ok := cfg.database.getChirp(report.ChirpId)
+			if !ok {
+				continue
+			}
+			entry = &queueEntry{Chirp: cfg.newChirpResponse(&moderator, chirp), Reasons: map[string]int{}}
+			entries[report.ChirpId] = entry
+		}
+
+		entry.ReportCount++
+		entry.Reasons[report.Reason]++
+		if entry.LastReportedAt == nil || report.CreatedAt.After(*entry.LastReportedAt) {
+			reportedAt := report.CreatedAt
+			entry.LastReportedAt = &reportedAt
+		}
+	}
+
+	for _, chirp := range cfg.database.listChirps() {
+		if _, ok := entries[chirp.Id]; chirp.Flagged && !ok {
+			entries[chirp.Id] = &queueEntry{Chirp: cfg.newChirpResponse(&moderator, chirp), Reasons: map[string]int{}}
+		}
+	}
+
+	queue := make([]queueEntry, 0, len(entries))
+	for _, entry := range entries {
+		queue = append(queue, *entry)
+	}
+	slices.SortFunc(queue, func(a, b queueEntry) int {
+		if a.ReportCount != b.ReportCount {
+			return b.ReportCount - a.ReportCount
+		}
+		return b.Chirp.Id - a.Chirp.Id
+	})
+
+	queue = queue[min(offset, len(queue)):]
+	respondWithJSON(w, 200, queue[:min(limit, len(queue))])
+}
+
+func (cfg *apiConfig) handlerGetChirpReports(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := cfg.moderationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	reports := []Report{}
+	for _, report := range cfg.database.listReports() {
+		if report.ChirpId == chirp.Id {
+			reports = append(reports, report)
+		}
+	}
+
+	respondWithJSON(w, 200, reports)
+}
+
+// handlerModerateChirp settles every open report on a chirp with a single decision
+func (cfg *apiConfig) handlerModerateChirp(w http.ResponseWriter, r *http.Request) {
+	moderator, _ := userFromContext(r.Context())
+
+	chirp, ok := cfg.moderationTarget(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || !slices.Contains(moderationActions, params.Action) {
+		respondWithError(w, 400, "action must be one of "+strings.Join(moderationActions, ", "))
+		return
+	}
+
+	switch params.Action {
+	case moderationDismiss, moderationHide:
+		// Dismissing also takes back an automatic hide, the reports turned out to be unfounded
+		if _, err := cfg.database.setChirpModeration(chirp.Id, params.Action == moderationHide, false); err != nil {
+			respondWithError(w, 500, "Failed to store chirp in database")
+			return
+		}
+
+	case moderationDelete:
+		if err := cfg.database.deleteChirp(chirp); err != nil {
+			respondWithError(w, 500, "Failed to delete chirp from database")
+			return
+		}
+
+	case moderationWarn:
+		_, err := cfg.database.updateChirp(chirp.Id, func(c *Chirp) {
+			c.Flagged = false
+		})
+		if err != nil {
+			respondWithError(w, 500, "Failed to store chirp in database")
+			return
+		}
+		cfg.notify(chirp.AuthorId, notificationWarning, moderator.Id, &chirp.Id)
+
+	case moderationSuspend:
+		author, ok := cfg.database.getUser(chirp.AuthorId)
+		if !ok {
+			respondWithError(w, 404, "Author does not exist")
+			return
+		}
+		if roleRank(author.Role) >= roleRank(moderator.Role) {
+			respondWithError(w, 403, "Insufficient permissions to suspend the author")
+			return
+		}
+
+		if _, err := cfg.database.setSuspended(author.Id, true); err != nil {
+			respondWithError(w, 500, "Failed to store user in database")
+			return
+		}
+		cfg.sockets.disconnectUser(author.Id, errSuspended.Error())
+		cfg.recordAudit(moderator, auditSuspended, author.Id, params.Note)
+
+		if _, err := cfg.database.setChirpModeration(chirp.Id, true, false); err != nil {
+			respondWithError(w, 500, "Failed to store chirp in database")
+			return
+		}
+	}
+
+	decision := ModerationDecision{
+		ChirpId:     chirp.Id,
+		AuthorId:    chirp.AuthorId,
+		ModeratorId: moderator.Id,
+		Action:      params.Action,
+		Note:        params.Note,
+		CreatedAt:   time.Now().UTC(),
+	}
+	decision, err := cfg.database.storeModerationDecision(decision, true)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store moderation decision in database")
+		return
+	}
+
+	respondWithJSON(w, 200, decision)
+}
+
+func (cfg *apiConfig) handlerGetModerationDecisions(w http.ResponseWriter, r *http.Request) {
+	var chirpId *int
+	if queryChirp := r.URL.Query().Get("chirp_id"); queryChirp != "" {
+		id, err := strconv.Atoi(queryChirp)
+		if err != nil {
+			respondWithError(w, 400, "chirp_id is not a valid number")
+			return
+		}
+		chirpId = &id
+	}
+
+	var authorId *int
+	if queryAuthor := r.URL.Query().Get("author_id"); queryAuthor != "" {
+		id, err := strconv.Atoi(queryAuthor)
+		if err != nil {
+			respondWithError(w, 400, "author_id is not a valid number")
+			return
+		}
+		authorId = &id
+	}
+
+	decisions := []ModerationDecision{}
+	for _, decision := range cfg.database.listModerationDecisions() {
+		if (chirpId == nil || *chirpId == decision.ChirpId) && (authorId == nil || *authorId == decision.AuthorId) {
+			decisions = append(decisions, decision)
+		}
+	}
+	slices.Reverse(decisions)
+
+	respondWithJSON(w, 200, decisions)
+}
+
+type Report struct {
+	Id         int       `json:"id"`
+	ChirpId    int       `json:"chirp_id"`
+	ReporterId int       `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+	// The decision that settled the report, open reports have none
+	DecisionId *int `json:"decision_id"`
+}
+
+type ModerationDecision struct {
+	Id          int    `json:"id"`
+	ChirpId     int    `json:"chirp_id"`
+	AuthorId    int    `json:"author_id"`
+	ModeratorId int    `json:"moderator_id"`
+	Action      string `json:"action"`
+	Note        string `json:"note"`
+	// The reports settled by the decision
+	ReportIds []int     `json:"report_ids"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	notificationMention    = "mention"
+	notificationReply      = "reply"
+	notificationLike       = "like"
+	notificationFollow     = "follow"
+	notificationRechirp    = "rechirp"
+	notificationRedUpgrade = "red_upgrade"
+)
+
+var notificationTypes = []string{
+	notificationMention,
+	notificationReply,
+	notificationLike,
+	notificationFollow,
+	notificationRechirp,
+	notificationRedUpgrade,
+}
+
+// wantsNotification reports whether a user has left notifications of a type switched on, every type is on by default
+func (u User) wantsNotification(kind string) bool {
+	enabled, ok := u.NotificationPreferences[kind]
+	return !ok || enabled
+}
+
+// notify records a notification for userId about something actorId did, actorId is 0 for notifications from
+// Chirpy itself. Failing to record is logged but never fails the action that caused it
+func (cfg *apiConfig) notify(userId int, kind string, actorId int, chirpId *int) {
+	if userId == actorId {
+		return
+	}
+
+	user, ok := cfg.database.getUser(userId)
+	if !ok || user.DeletedAt != nil || !user.wantsNotification(kind) {
+		return
+	}
+	// Warnings come from the moderators as a whole, blocking or muting one of them does not keep them out
+	if kind != notificationWarning && (cfg.database.isBlocked(userId, actorId) || cfg.database.hasMuted(userId, actorId)) {
+		return
+	}
+
+	notification := Notification{
+		UserId:    userId,
+		Type:      kind,
+		ActorId:   actorId,
+		ChirpId:   chirpId,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if _, err := cfg.database.storeNotification(notification); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store notification: %s\n", err)
+	}
+}
+
+type notificationResponse struct {
+	Notification
+	Actor *userProfile `json:"actor"`
+}
+
+func (cfg *apiConfig) newNotificationResponse(notification Notification) notificationResponse {
+	var actor *userProfile
+	if user, ok := cfg.database.getUser(notification.ActorId); ok {
+		profile := cfg.newUserProfile(user)
+		actor = &profile
+	}
+
+	return notificationResponse{notification, actor}
+}
+
+// notificationVisible reports whether a notification is still worth showing, it is not once the chirp is gone
+// out of sight or the actor is pending deletion, blocked or muted. Warnings do not depend on the moderator
+// who sent them
+func (cfg *apiConfig) notificationVisible(user User, notification Notification) bool {
+	if notification.ActorId != 0 && notification.Type != notificationWarning {
+		actor, ok := cfg.database.getUser(notification.ActorId)
+		if !ok || actor.DeletedAt != nil {
+			return false
+		}
+		if cfg.database.isBlocked(user.Id, actor.Id) || cfg.database.hasMuted(user.Id, actor.Id) {
+			return false
+		}
+	}
+	if notification.ChirpId != nil {
+		chirp, ok := cfg.database.getChirp(*notification.ChirpId)
+		if !ok || !cfg.canView(&user, chirp) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// visibleNotifications lists the notifications of a user newest first, leaving out the ones no longer visible
+func (cfg *apiConfig) visibleNotifications(user User) []Notification {
+	notifications := []Notification{}
+	all := cfg.database.listNotifications(user.Id)
+	for i := len(all) - 1; i >= 0; i-- {
+		if cfg.notificationVisible(user, all[i]) {
+			notifications = append(notifications, all[i])
+		}
+	}
+
+	return notifications
+}
+
+func (cfg *apiConfig) handlerGetNotifications(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	unreadOnly := false
+	if queryUnread := r.URL.Query().Get("unread"); queryUnread != "" {
+		unreadOnly, err = strconv.ParseBool(queryUnread)
+		if err != nil {
+			respondWithError(w, 400, "unread must be true or false")
+			return
+		}
+	}
+
+	type response struct {
+		UnreadCount   int                    `json:"unread_count"`
+		Notifications []notificationResponse `json:"notifications"`
+	}
+
+	resp := response{Notifications: []notificationResponse{}}
+	skipped := 0
+	for _, notification := range cfg.visibleNotifications(user) {
+		if !notification.Read {
+			resp.UnreadCount++
+		} else if unreadOnly {
+			continue
+		}
+
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if len(resp.Notifications) == limit {
+			continue
+		}
+
+		resp.Notifications = append(resp.Notifications, cfg.newNotificationResponse(notification))
+	}
+
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerReadNotification(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	notificationID, err := strconv.Atoi(r.PathValue("notificationID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	if !cfg.database.markNotificationsRead(user.Id, notificationID, notificationID) {
+		respondWithError(w, 404, "Notification does not exist")
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// handlerReadAllNotifications marks everything up to up_to_id as read, or every notification without it so
+// that clients can avoid marking notifications that arrived after the list was fetched
+func (cfg *apiConfig) handlerReadAllNotifications(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	type parameters struct {
+		UpToId *int `json:"up_to_id"`
+	}
+
+	var params parameters
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, 400, "Invalid request body")
+			return
+		}
+	}
+
+	upToId := int(^uint(0) >> 1)
+	if params.UpToId != nil {
+		upToId = *params.UpToId
+	}
+
+	cfg.database.markNotificationsRead(user.Id, 0, upToId)
+
+	w.WriteHeader(204)
+}
+
+func notificationPreferences(user User) map[string]bool {
+	preferences := map[string]bool{}
+	for _, kind := range notificationTypes {
+		preferences[kind] = user.wantsNotification(kind)
+	}
+
+	return preferences
+}
+
+func (cfg *apiConfig) handlerGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	respondWithJSON(w, 200, notificationPreferences(user))
+}
+
+// handlerUpdateNotificationPreferences switches notification types on or off, types left out keep their setting
+func (cfg *apiConfig) handlerUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	var params map[string]bool
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Preferences must be an object of notification types to true or false")
+		return
+	}
+
+	for kind := range params {
+		if !slices.Contains(notificationTypes, kind) {
+			respondWithError(w, 400, fmt.Sprintf("Unknown notification type %q", kind))
+			return
+		}
+	}
+
+	user, err = cfg.database.updateNotificationPreferences(user.Id, params)
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+
+	respondWithJSON(w, 200, notificationPreferences(user))
+}
+
+type Notification struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorId   int       `json:"actor_id"`
+	ChirpId   *int      `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read"`
+}
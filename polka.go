@@ -75,8 +75,19 @@ func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	user.Red = true
-	cfg.database.storeUser(*user)
+	wasRed := false
+	_, err := cfg.database.updateUser(user.Id, func(u *User) error {
+		wasRed = u.Red
+		u.Red = true
+		return nil
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	if !wasRed {
+		cfg.notify(user.Id, notificationRedUpgrade, 0, nil)
+	}
 
 	w.WriteHeader(204)
 }
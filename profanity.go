@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	profanityMask   = "mask"
+	profanityReject = "reject"
+	profanityFlag   = "flag"
+)
+
+var errProfanity = errors.New("Chirp contains profanity")
+
+// defaultProfanityWords are used when no PROFANITY_FILE is configured
+var defaultProfanityWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// leetLetters undoes the usual letter substitutions, a1 → ai
+var leetLetters = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'i', '+': 't', '€': 'e', '£': 'l',
+}
+
+// profanityFilter holds the current word list, it can be swapped out while the server is running
+type profanityFilter struct {
+	mu     sync.RWMutex
+	path   string
+	action string
+	// words maps the skeleton of every listed word to how often each of its letters repeats
+	words map[string][][]int
+}
+
+func newProfanityFilter(path, action string) (*profanityFilter, error) {
+	switch action {
+	case "":
+		action = profanityMask
+	case profanityMask, profanityReject, profanityFlag:
+	default:
+		return nil, fmt.Errorf("unknown profanity action %q, must be mask, reject or flag", action)
+	}
+
+	f := &profanityFilter{path: path, action: action}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// reload reads the word list again, one word per line with # starting a comment. On error the
+// current list stays in use
+func (f *profanityFilter) reload() error {
+	words := defaultProfanityWords
+	if f.path != "" {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		words = []string{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if word := strings.TrimSpace(line); word != "" {
+				words = append(words, word)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	skeletons := map[string][][]int{}
+	for _, word := range words {
+		if skeleton, runs := profanitySkeleton(word); skeleton != "" {
+			skeletons[skeleton] = append(skeletons[skeleton], runs)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.words = skeletons
+
+	return nil
+}
+
+// foldRune maps a rune to the same rune for every case variant of it, the smallest of its case folding orbit
+func foldRune(r rune) rune {
+	folded := r
+	for next := unicode.SimpleFold(r); next != r; next = unicode.SimpleFold(next) {
+		folded = min(folded, next)
+	}
+
+	return folded
+}
+
+// profanitySkeleton reduces a word to what it reads as: case folded, substitutions undone, anything that
+// is not a letter dropped and repeated letters collapsed, so "K.E.R.F.U.U.F.F.L.3" reads as kerfufle.
+// runs keeps how often each letter of the skeleton was repeated
+func profanitySkeleton(word string) (skeleton string, runs []int) {
+	var b strings.Builder
+	var last rune
+	for _, r := range word {
+		if l, ok := leetLetters[r]; ok {
+			r = l
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+
+		r = foldRune(r)
+		if r == last {
+			runs[len(runs)-1]++
+			continue
+		}
+		b.WriteRune(r)
+		runs = append(runs, 1)
+		last = r
+	}
+
+	return b.String(), runs
+}
+
+// readsAs checks a word reduced by profanitySkeleton against a listed one. Letters may be repeated more
+// often than in the listed word but not less, so stretching a word still matches while "pop" does not
+// read as "poop"
+func readsAs(skeleton string, runs []int, listedSkeleton string, listedRuns []int) bool {
+	if skeleton != listedSkeleton {
+		return false
+	}
+	for i := range listedRuns {
+		if runs[i] < listedRuns[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listed checks whether word reads as any word on the list, f.mu must be held
+func (f *profanityFilter) listed(word string) bool {
+	skeleton, runs := profanitySkeleton(word)
+	for _, listedRuns := range f.words[skeleton] {
+		if readsAs(skeleton, runs, skeleton, listedRuns) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// profaneSpans finds the rune ranges of a token to be masked. The token is tried trimmed of its punctuation
+// first, then as a whole for words that start or end in a substitution, then piece by piece. A leading @ is
+// the sigil of a mention rather than a substitution, so @user is only ever judged by the name
+func (f *profanityFilter) profaneSpans(token []rune) [][2]int {
+	start, end := 0, len(token)
+	for start < end && !isWordRune(token[start]) {
+		start++
+	}
+	for end > start && !isWordRune(token[end-1]) {
+		end--
+	}
+	if start == end {
+		return nil
+	}
+
+	if f.listed(string(token[start:end])) {
+		return [][2]int{{start, end}}
+	}
+	if token[0] != '@' && f.listed(string(token)) {
+		return [][2]int{{0, len(token)}}
+	}
+
+	spans := [][2]int{}
+	pieceStart := start
+	for i := start; i <= end; i++ {
+		if i < end {
+			if _, leet := leetLetters[token[i]]; isWordRune(token[i]) || leet {
+				continue
+			}
+		}
+		if pieceStart < i && f.listed(string(token[pieceStart:i])) {
+			spans = append(spans, [2]int{pieceStart, i})
+		}
+		pieceStart = i + 1
+	}
+
+	return spans
+}
+
+// check masks every profane word in body with as many asterisks as it has characters, reporting whether any was found
+func (f *profanityFilter) check(body string) (string, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	runes := []rune(body)
+	found := false
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(runes) && !unicode.IsSpace(runes[end]) {
+			end++
+		}
+
+		for _, span := range f.profaneSpans(runes[i:end]) {
+			found = true
+			for j := i + span[0]; j < i+span[1]; j++ {
+				runes[j] = '*'
+			}
+		}
+		i = end
+	}
+
+	return string(runes), found
+}
+
+// apply runs the configured action over a chirp body, returning the body to store and whether it needs review
+func (f *profanityFilter) apply(body string) (string, bool, error) {
+	masked, found := f.check(body)
+	if !found {
+		return body, false, nil
+	}
+
+	switch f.action {
+	case profanityReject:
+		return "", false, errProfanity
+	case profanityFlag:
+		return body, true, nil
+	}
+
+	return masked, false, nil
+}
+
+func (cfg *apiConfig) handlerReloadProfanity(w http.ResponseWriter, r *http.Request) {
+	admin, _ := userFromContext(r.Context())
+
+	if err := cfg.profanity.reload(); err != nil {
+		respondWithError(w, 500, fmt.Sprintf("Failed to reload profanity list: %s", err))
+		return
+	}
+
+	cfg.profanity.mu.RLock()
+	count := len(cfg.profanity.words)
+	cfg.profanity.mu.RUnlock()
+
+	cfg.recordAudit(admin, auditProfanityReloaded, 0, fmt.Sprintf("%d words", count))
+	respondWithJSON(w, 200, struct {
+		Words int `json:"words"`
+	}{count})
+}
+
+// handlerGetFlaggedChirps lists the chirps the profanity filter flagged for review, newest first
+func (cfg *apiConfig) handlerGetFlaggedChirps(w http.ResponseWriter, r *http.Request) {
+	moderator, _ := userFromContext(r.Context())
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	chirps := []chirpResponse{}
+	skipped := 0
+	all := cfg.database.listChirps()
+	for i := len(all) - 1; i >= 0 && len(chirps) < limit; i-- {
+		if !all[i].Flagged {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		chirps = append(chirps, cfg.newChirpResponse(&moderator, all[i]))
+	}
+
+	respondWithJSON(w, 200, chirps)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestProfanityFilter(t *testing.T, action string) *profanityFilter {
+	t.Helper()
+
+	f, err := newProfanityFilter("", action)
+	if err != nil {
+		t.Fatalf("newProfanityFilter returned error %v", err)
+	}
+
+	return f
+}
+
+func TestProfanitySkeleton(t *testing.T) {
+	// Skeletons are an internal form, so words are compared by whether one reads as the other
+	tests := []struct {
+		word string
+		as   string
+		same bool
+	}{
+		{"KerFuffle", "kerfuffle", true},
+		{"k3rfuffl3", "kerfuffle", true},
+		{"K.E.R.F.U.F.F.L.E", "kerfuffle", true},
+		{"kerrrfuuuffle", "kerfuffle", true},
+		{"\u212Aerfuffle", "kerfuffle", true}, // Kelvin sign
+		{"\u017Fharbert", "sharbert", true},   // long s
+		{"sh@rbert", "sharbert", true},
+		{"$harbert", "sharbert", true},
+		{"f0rn4x", "fornax", true},
+		{"sh!p", "ship", true},
+		{"poooop", "poop", true},
+		{"pop", "poop", false},
+		{"kerfufle", "kerfuffle", false},
+		{"kerfuffled", "kerfuffle", false},
+		{"sharbet", "sharbert", false},
+		{"fornaxes", "fornax", false},
+	}
+
+	for _, tt := range tests {
+		skeleton, runs := profanitySkeleton(tt.word)
+		listedSkeleton, listedRuns := profanitySkeleton(tt.as)
+		if got := readsAs(skeleton, runs, listedSkeleton, listedRuns); got != tt.same {
+			t.Errorf("%q reads as %q is %v, want %v", tt.word, tt.as, got, tt.same)
+		}
+	}
+
+	want, _ := profanitySkeleton("ii")
+	if got, _ := profanitySkeleton("...!?"); got != want {
+		t.Errorf("profanitySkeleton(%q) = %q, only the ! substitutions should be left", "...!?", got)
+	}
+	if got, _ := profanitySkeleton("..."); got != "" {
+		t.Errorf("profanitySkeleton(%q) = %q, want an empty skeleton", "...", got)
+	}
+}
+
+func TestProfaneSpans(t *testing.T) {
+	f := newTestProfanityFilter(t, profanityMask)
+
+	tests := []struct {
+		token string
+		want  [][2]int
+	}{
+		{"kerfuffle", [][2]int{{0, 9}}},
+		{"kerfuffle!", [][2]int{{0, 9}}},
+		{"(kerfuffle),", [][2]int{{1, 10}}},
+		{"$harbert", [][2]int{{0, 8}}},
+		{"kerfuffle/fornax", [][2]int{{0, 9}, {10, 16}}},
+		{"@kerfuffle", [][2]int{{1, 10}}},
+		{"@alice", [][2]int{}},
+		{"@sharbertfan", [][2]int{}},
+		{"kerfuffled", [][2]int{}},
+		{"fornaxes", [][2]int{}},
+		{"wow!!!", [][2]int{}},
+		{"...", nil},
+	}
+
+	for _, tt := range tests {
+		got := f.profaneSpans([]rune(tt.token))
+		if len(got) != len(tt.want) || (len(got) > 0 && !equalSpans(got, tt.want)) {
+			t.Errorf("profaneSpans(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+}
+
+func equalSpans(a, b [][2]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestProfanityCheck(t *testing.T) {
+	f := newTestProfanityFilter(t, profanityMask)
+
+	tests := []struct {
+		body  string
+		want  string
+		found bool
+	}{
+		{"what a kerfuffle", "what a *********", true},
+		{"What a KERFUFFLE!", "What a *********!", true},
+		{"k3rfuffl3 and sh@rbert", "********* and ********", true},
+		{"kerrrfuffle", "***********", true},
+		{"Kerfuffle", "*********", true},
+		{"no fornax, no sharbert.", "no ******, no ********.", true},
+		{"ping @kerfuffle", "ping @*********", true},
+		{"ping @alice!", "ping @alice!", false},
+		{"a kerfuffled sharbet", "a kerfuffled sharbet", false},
+		{"this is fine", "this is fine", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, found := f.check(tt.body)
+		if got != tt.want || found != tt.found {
+			t.Errorf("check(%q) = %q, %v, want %q, %v", tt.body, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestProfanityApply(t *testing.T) {
+	tests := []struct {
+		action  string
+		body    string
+		want    string
+		flagged bool
+		err     error
+	}{
+		{profanityMask, "a kerfuffle", "a *********", false, nil},
+		{profanityMask, "all good", "all good", false, nil},
+		{profanityReject, "a kerfuffle", "", false, errProfanity},
+		{profanityReject, "all good", "all good", false, nil},
+		{profanityFlag, "a kerfuffle", "a kerfuffle", true, nil},
+		{profanityFlag, "all good", "all good", false, nil},
+	}
+
+	for _, tt := range tests {
+		f := newTestProfanityFilter(t, tt.action)
+		got, flagged, err := f.apply(tt.body)
+		if got != tt.want || flagged != tt.flagged || !errors.Is(err, tt.err) {
+			t.Errorf("%s apply(%q) = %q, %v, %v, want %q, %v, %v", tt.action, tt.body, got, flagged, err, tt.want, tt.flagged, tt.err)
+		}
+	}
+
+	if _, err := newProfanityFilter("", "shout"); err == nil {
+		t.Errorf("newProfanityFilter with an unknown action succeeded, want an error")
+	}
+}
+
+func TestProfanityReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profanity.txt")
+	if err := os.WriteFile(path, []byte("# house rules\nflumph\n  grommet  # trailing comment\n\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := newProfanityFilter(path, profanityMask)
+	if err != nil {
+		t.Fatalf("newProfanityFilter returned error %v", err)
+	}
+
+	if got, _ := f.check("flumph grommet kerfuffle"); got != "****** ******* kerfuffle" {
+		t.Errorf("check with the file's words = %q, the defaults must not apply when a file is given", got)
+	}
+
+	if err := os.WriteFile(path, []byte("kerfuffle\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.reload(); err != nil {
+		t.Fatalf("reload returned error %v", err)
+	}
+	if got, _ := f.check("flumph kerfuffle"); got != "flumph *********" {
+		t.Errorf("check after reload = %q, want %q", got, "flumph *********")
+	}
+
+	// Double letters in a listed word must be there, "pop" is a word of its own
+	if err := os.WriteFile(path, []byte("poop\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.reload(); err != nil {
+		t.Fatalf("reload returned error %v", err)
+	}
+	if got, found := f.check("pop poop pooooop"); got != "pop **** *******" || !found {
+		t.Errorf("check with a double letter word = %q, %v, want %q, true", got, found, "pop **** *******")
+	}
+
+	// A list that can not be read leaves the current one in place
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.reload(); err == nil {
+		t.Errorf("reload of a missing file succeeded, want an error")
+	}
+	if got, _ := f.check("poop"); got != "****" {
+		t.Errorf("check after a failed reload = %q, want the previous list to still apply", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const (
+	profileAvatar = "avatar"
+	profileBanner = "banner"
+)
+
+// profileImageSizes are the standard dimensions profile images are cropped and resized to
+var profileImageSizes = map[string]struct{ width, height int }{
+	profileAvatar: {400, 400},
+	profileBanner: {1500, 500},
+}
+
+// processProfileImage crops an uploaded image to the aspect ratio of kind and resizes it to the standard
+// dimensions. Photos stay JPEG, everything else becomes PNG, GIFs lose their animation
+func processProfileImage(data []byte, kind string) ([]byte, string, error) {
+	contentType, err := sniffImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, err := decodeImage(data, contentType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if contentType != "image/jpeg" {
+		contentType = "image/png"
+	}
+
+	size := profileImageSizes[kind]
+	resized := resizeImage(cropToAspect(img, size.width, size.height), size.width, size.height)
+	encoded, err := encodeImage(resized, contentType)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return encoded, imageFormats[contentType].ext, nil
+}
+
+// profileImageKey is where a user keeps the image of kind
+func (u *User) profileImageKey(kind string) *string {
+	if kind == profileBanner {
+		return &u.BannerKey
+	}
+
+	return &u.AvatarKey
+}
+
+// profileImageUrl is nil for users without an image of that kind
+func (cfg *apiConfig) profileImageUrl(key string) *string {
+	if key == "" {
+		return nil
+	}
+
+	url := cfg.blobs.url(key)
+	return &url
+}
+
+// removeProfileImages deletes the avatar and banner of a user that is being purged
+func (cfg *apiConfig) removeProfileImages(user User) {
+	for _, key := range []string{user.AvatarKey, user.BannerKey} {
+		if key == "" {
+			continue
+		}
+		if err := cfg.blobs.remove(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to remove blob %s: %s\n", key, err)
+		}
+	}
+}
+
+// handlerUploadProfileImage returns the handler replacing the avatar or banner with the "image" field of
+// a multipart form. Every upload gets a new key, so a URL keeps pointing at the same image and can be cached forever
+func (cfg *apiConfig) handlerUploadProfileImage(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+
+		data, err := readUpload(w, r, "image", cfg.maxUploadSize)
+		if err != nil {
+			respondWithUploadError(w, err)
+			return
+		}
+
+		encoded, ext, err := processProfileImage(data, kind)
+		if err != nil {
+			respondWithUploadError(w, err)
+			return
+		}
+
+		suffix, err := randomHex(8)
+		if err != nil {
+			respondWithError(w, 500, "Failed to store "+kind)
+			return
+		}
+
+		key := fmt.Sprintf("%s_%d_%s%s", kind, user.Id, suffix, ext)
+		if err := cfg.blobs.put(key, encoded); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store %s of user %d: %s\n", kind, user.Id, err)
+			respondWithError(w, 500, "Failed to store "+kind)
+			return
+		}
+
+		user, previous, err := cfg.database.setProfileImage(user.Id, kind, key)
+		if err != nil {
+			cfg.blobs.remove(key)
+			respondWithError(w, 500, "Failed to store user in database")
+			return
+		}
+		if previous != "" {
+			cfg.blobs.remove(previous)
+		}
+
+		respondWithJSON(w, 200, cfg.newUserProfile(user))
+	}
+}
+
+// handlerDeleteProfileImage returns the handler removing the avatar or banner, users without one get a 204 too
+func (cfg *apiConfig) handlerDeleteProfileImage(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := cfg.authenticate(r)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+
+		_, previous, err := cfg.database.setProfileImage(user.Id, kind, "")
+		if err != nil {
+			respondWithError(w, 500, "Failed to store user in database")
+			return
+		}
+		if previous != "" {
+			if err := cfg.blobs.remove(previous); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to remove blob %s: %s\n", previous, err)
+			}
+		}
+
+		w.WriteHeader(204)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handlerRechirp reposts a chirp as is, rechirping the same chirp twice hands back the existing rechirp
+func (cfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	target, ok := cfg.likeTarget(w, r, user)
+	if !ok {
+		return
+	}
+	originalId := target.originalId()
+
+	// Rechirping would show followers-only and mentioned-only chirps to people they were not meant for
+	original, _ := cfg.database.getChirp(originalId)
+	if original.Visibility != visibilityPublic && original.Visibility != visibilityUnlisted {
+		respondWithError(w, 400, "Only public and unlisted chirps can be rechirped")
+		return
+	}
+
+	chirp := Chirp{AuthorId: user.Id, CreatedAt: time.Now().UTC(), RechirpOf: &originalId, Visibility: original.Visibility}
+	chirp, created := cfg.database.storeRechirp(chirp)
+	if !created {
+		respondWithJSON(w, 200, cfg.newChirpResponse(&user, chirp))
+		return
+	}
+	cfg.notify(original.AuthorId, notificationRechirp, user.Id, &originalId)
+
+	respondWithJSON(w, 201, cfg.newChirpResponse(&user, chirp))
+}
+
+// handlerUndoRechirp skips the visibility check of likeTarget like handlerUnlikeChirp does, a rechirp stays
+// removable after the chirp has gone out of sight
+func (cfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	target, ok := cfg.database.getChirp(chirpID)
+	if !ok {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	if existing, ok := cfg.database.findRechirp(user.Id, target.originalId()); ok {
+		cfg.database.deleteChirp(existing)
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BM25 tuning, the usual defaults
+const (
+	searchK1 = 1.2
+	searchB  = 0.75
+	// maxPrefixTerms caps how many index terms a single prefix query expands to
+	maxPrefixTerms = 256
+)
+
+type searchDoc struct {
+	authorId  int
+	createdAt time.Time
+	length    int
+}
+
+// searchIndex is an inverted index over chirp bodies with term positions for phrase queries,
+// the terms are also kept sorted so prefix queries are a range scan
+type searchIndex struct {
+	mu          sync.Mutex
+	postings    map[string]map[int][]int
+	terms       []string
+	docs        map[int]searchDoc
+	totalLength int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[int][]int{},
+		docs:     map[int]searchDoc{},
+	}
+}
+
+func isSearchRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+// searchTokens splits text into lowercase words, # and @ are dropped so tags and mentions match plain words.
+// An apostrophe within a word is dropped as well, so a contraction like don't stays the single term dont
+func searchTokens(text string) []string {
+	tokens := []string{}
+	var word strings.Builder
+	runes := []rune(strings.ToLower(text))
+	for i, r := range runes {
+		switch {
+		case isSearchRune(r):
+			word.WriteRune(r)
+		case isApostrophe(r) && word.Len() > 0 && i+1 < len(runes) && unicode.IsLetter(runes[i+1]):
+		case word.Len() > 0:
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	if word.Len() > 0 {
+		tokens = append(tokens, word.String())
+	}
+
+	return tokens
+}
+
+func (idx *searchIndex) add(chirp Chirp) {
+	tokens := searchTokens(chirp.Body)
+	if len(tokens) == 0 {
+		return
+	}
+
+	idx.docs[chirp.Id] = searchDoc{authorId: chirp.AuthorId, createdAt: chirp.CreatedAt, length: len(tokens)}
+	idx.totalLength += len(tokens)
+	for position, term := range tokens {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int][]int{}
+			i, _ := slices.BinarySearch(idx.terms, term)
+			idx.terms = slices.Insert(idx.terms, i, term)
+		}
+		idx.postings[term][chirp.Id] = append(idx.postings[term][chirp.Id], position)
+	}
+}
+
+func (idx *searchIndex) remove(chirp Chirp) {
+	doc, ok := idx.docs[chirp.Id]
+	if !ok {
+		return
+	}
+
+	delete(idx.docs, chirp.Id)
+	idx.totalLength -= doc.length
+	for _, term := range searchTokens(chirp.Body) {
+		delete(idx.postings[term], chirp.Id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			if i, found := slices.BinarySearch(idx.terms, term); found {
+				idx.terms = slices.Delete(idx.terms, i, i+1)
+			}
+		}
+	}
+}
+
+// handleChirpEvent keeps the index in step with the database, it is registered through onChirpEvent
+func (idx *searchIndex) handleChirpEvent(e chirpEvent) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	switch e.Kind {
+	case chirpCreated:
+		idx.add(e.Chirp)
+	case chirpUpdated:
+		if e.Previous != nil {
+			idx.remove(*e.Previous)
+		}
+		idx.add(e.Chirp)
+	case chirpDeleted:
+		idx.remove(e.Chirp)
+	}
+}
+
+// searchClause is one part of a query, every clause has to match for a chirp to be found
+type searchClause struct {
+	terms  []string
+	prefix bool
+}
+
+func (c searchClause) isPhrase() bool {
+	return len(c.terms) > 1
+}
+
+// parseSearchQuery understands "quoted phrases", prefix* matches and plain words
+func parseSearchQuery(q string) ([]searchClause, error) {
+	clauses := []searchClause{}
+
+	parts := strings.Split(q, `"`)
+	if len(parts)%2 == 0 {
+		return nil, errors.New("q has an unterminated phrase")
+	}
+
+	for i, part := range parts {
+		if i%2 == 1 {
+			if terms := searchTokens(part); len(terms) > 0 {
+				clauses = append(clauses, searchClause{terms: terms})
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			terms := searchTokens(word)
+			for _, term := range terms {
+				clauses = append(clauses, searchClause{terms: []string{term}})
+			}
+			if strings.HasSuffix(word, "*") && len(terms) > 0 {
+				clauses[len(clauses)-1].prefix = true
+			}
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, errors.New("q must contain at least one word")
+	}
+
+	return clauses, nil
+}
+
+// clauseFrequencies returns how often a clause occurs in every chirp it matches
+func (idx *searchIndex) clauseFrequencies(clause searchClause) map[int]int {
+	frequencies := map[int]int{}
+
+	if clause.prefix {
+		start, _ := slices.BinarySearch(idx.terms, clause.terms[0])
+		for i := start; i < len(idx.terms) && i-start < maxPrefixTerms; i++ {
+			if !strings.HasPrefix(idx.terms[i], clause.terms[0]) {
+				break
+			}
+			for id, positions := range idx.postings[idx.terms[i]] {
+				frequencies[id] += len(positions)
+			}
+		}
+		return frequencies
+	}
+
+	if !clause.isPhrase() {
+		for id, positions := range idx.postings[clause.terms[0]] {
+			frequencies[id] = len(positions)
+		}
+		return frequencies
+	}
+
+	for id, starts := range idx.postings[clause.terms[0]] {
+		for _, start := range starts {
+			matched := true
+			for offset, term := range clause.terms[1:] {
+				if !slices.Contains(idx.postings[term][id], start+offset+1) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				frequencies[id]++
+			}
+		}
+	}
+
+	return frequencies
+}
+
+type searchFilter struct {
+	authorId *int
+	since    *time.Time
+	until    *time.Time
+}
+
+type searchHit struct {
+	chirpId int
+	score   float64
+}
+
+// search ranks every chirp matching all clauses with BM25, best matches first and newer chirps first on ties
+func (idx *searchIndex) search(clauses []searchClause, filter searchFilter) []searchHit {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if len(idx.docs) == 0 {
+		return []searchHit{}
+	}
+	docCount := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / docCount
+
+	scores := map[int]float64{}
+	for i, clause := range clauses {
+		frequencies := idx.clauseFrequencies(clause)
+		idf := math.Log(1 + (docCount-float64(len(frequencies))+0.5)/(float64(len(frequencies))+0.5))
+
+		next := map[int]float64{}
+		for id, tf := range frequencies {
+			previous, ok := scores[id]
+			if i > 0 && !ok {
+				continue
+			}
+
+			doc := idx.docs[id]
+			if filter.authorId != nil && doc.authorId != *filter.authorId {
+				continue
+			}
+			if (filter.since != nil && doc.createdAt.Before(*filter.since)) || (filter.until != nil && !doc.createdAt.Before(*filter.until)) {
+				continue
+			}
+
+			norm := 1 - searchB + searchB*float64(doc.length)/avgLength
+			next[id] = previous + idf*float64(tf)*(searchK1+1)/(float64(tf)+searchK1*norm)
+		}
+		scores = next
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{id, score})
+	}
+	slices.SortFunc(hits, func(a, b searchHit) int {
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return b.chirpId - a.chirpId
+	})
+
+	return hits
+}
+
+// parseSearchTime accepts either a full RFC 3339 timestamp or a plain date
+func parseSearchTime(value string) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (cfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	clauses, err := parseSearchQuery(query.Get("q"))
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	filter := searchFilter{}
+	if queryAuthor := query.Get("author_id"); queryAuthor != "" {
+		authorId, err := strconv.Atoi(queryAuthor)
+		if err != nil {
+			respondWithError(w, 400, "author_id is not a valid number")
+			return
+		}
+		filter.authorId = &authorId
+	}
+	if querySince := query.Get("since"); querySince != "" {
+		if filter.since, err = parseSearchTime(querySince); err != nil {
+			respondWithError(w, 400, "since must be a date or an RFC 3339 timestamp")
+			return
+		}
+	}
+	if queryUntil := query.Get("until"); queryUntil != "" {
+		if filter.until, err = parseSearchTime(queryUntil); err != nil {
+			respondWithError(w, 400, "until must be a date or an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	sortRecent := false
+	switch query.Get("sort") {
+	case "", "relevance":
+	case "recent":
+		sortRecent = true
+	default:
+		respondWithError(w, 400, "sort must be relevance or recent")
+		return
+	}
+
+	hits := cfg.search.search(clauses, filter)
+	if sortRecent {
+		slices.SortFunc(hits, func(a, b searchHit) int {
+			return b.chirpId - a.chirpId
+		})
+	}
+
+	type searchResult struct {
+		chirpResponse
+		Score float64 `json:"score"`
+	}
+
+	viewer := cfg.optionalViewer(r)
+	results := []searchResult{}
+	skipped := 0
+	for _, hit := range hits {
+		chirp, ok := cfg.database.getChirp(hit.chirpId)
+		if !ok || !chirp.listed() || !cfg.inFeed(viewer, chirp) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+
+		results = append(results, searchResult{cfg.newChirpResponse(viewer, chirp), hit.score})
+		if len(results) == limit {
+			break
+		}
+	}
+
+	respondWithJSON(w, 200, results)
+}
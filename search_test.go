@@ -0,0 +1,152 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"#Golang and @alice", []string{"golang", "and", "alice"}},
+		{"don't stop", []string{"dont", "stop"}},
+		{"it’s fine", []string{"its", "fine"}},
+		{"rock 'n' roll", []string{"rock", "n", "roll"}},
+		{"the dogs' bowls", []string{"the", "dogs", "bowls"}},
+		{"café naïve", []string{"café", "naïve"}},
+		{"route66 v2.0", []string{"route66", "v2", "0"}},
+		{"  ...  ", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := searchTokens(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("searchTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []searchClause
+	}{
+		{"cats", []searchClause{{terms: []string{"cats"}}}},
+		{"don't panic", []searchClause{{terms: []string{"dont"}}, {terms: []string{"panic"}}}},
+		{`"big dog" cat*`, []searchClause{{terms: []string{"big", "dog"}}, {terms: []string{"cat"}, prefix: true}}},
+		{"wi-fi", []searchClause{{terms: []string{"wi"}}, {terms: []string{"fi"}}}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.q)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q) returned error %v", tt.q, err)
+			continue
+		}
+		if !slices.EqualFunc(got, tt.want, func(a, b searchClause) bool {
+			return a.prefix == b.prefix && slices.Equal(a.terms, b.terms)
+		}) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+	}
+
+	for _, q := range []string{"", "  ", `"unterminated`, "#@!"} {
+		if _, err := parseSearchQuery(q); err == nil {
+			t.Errorf("parseSearchQuery(%q) succeeded, want an error", q)
+		}
+	}
+}
+
+func newTestSearchIndex(bodies ...string) *searchIndex {
+	idx := newSearchIndex()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, body := range bodies {
+		idx.handleChirpEvent(chirpEvent{Kind: chirpCreated, Chirp: Chirp{
+			Id:        i + 1,
+			Body:      body,
+			AuthorId:  i%2 + 1,
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}})
+	}
+
+	return idx
+}
+
+func searchIds(t *testing.T, idx *searchIndex, q string, filter searchFilter) []int {
+	t.Helper()
+
+	clauses, err := parseSearchQuery(q)
+	if err != nil {
+		t.Fatalf("parseSearchQuery(%q) returned error %v", q, err)
+	}
+
+	ids := []int{}
+	for _, hit := range idx.search(clauses, filter) {
+		ids = append(ids, hit.chirpId)
+	}
+
+	return ids
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := newTestSearchIndex(
+		"cats are great",                          // 1
+		"cats cats cats everywhere",               // 2
+		"dogs are great and so are cats and more", // 3
+		"a great big dog",                         // 4
+		"I don't like big dogs",                   // 5
+		"dogs are great",                          // 6
+		"catalogue of great things",               // 7
+	)
+	authorOne := 1
+	since := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		q      string
+		filter searchFilter
+		want   []int
+	}{
+		{"more occurrences rank higher", "cats", searchFilter{}, []int{2, 1, 3}},
+		{"every clause must match", "cats great", searchFilter{}, []int{1, 3}},
+		{"phrase needs adjacent terms", `"big dog"`, searchFilter{}, []int{4}},
+		{"prefix expands to every term", "cat*", searchFilter{}, []int{2, 1, 7, 3}},
+		{"contraction is a single term", "don't", searchFilter{}, []int{5}},
+		{"equal scores put newer chirps first", `"are great"`, searchFilter{}, []int{6, 1, 3}},
+		{"author filter", "great", searchFilter{authorId: &authorOne}, []int{1, 7, 3}},
+		{"since filter", "dogs", searchFilter{since: &since}, []int{6, 5}},
+		{"no match", "elephants", searchFilter{}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchIds(t, idx, tt.q, tt.filter); !slices.Equal(got, tt.want) {
+				t.Errorf("search(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchIndexFollowsUpdatesAndDeletes(t *testing.T) {
+	idx := newTestSearchIndex("hello world", "goodbye world")
+
+	previous := Chirp{Id: 1, Body: "hello world", AuthorId: 2}
+	idx.handleChirpEvent(chirpEvent{Kind: chirpUpdated, Chirp: Chirp{Id: 1, Body: "farewell world", AuthorId: 2}, Previous: &previous})
+	if got := searchIds(t, idx, "hello", searchFilter{}); len(got) != 0 {
+		t.Errorf("search for the edited out word = %v, want nothing", got)
+	}
+	if got := searchIds(t, idx, "farewell", searchFilter{}); !slices.Equal(got, []int{1}) {
+		t.Errorf("search for the edited in word = %v, want [1]", got)
+	}
+
+	idx.handleChirpEvent(chirpEvent{Kind: chirpDeleted, Chirp: Chirp{Id: 2, Body: "goodbye world"}})
+	if got := searchIds(t, idx, "world", searchFilter{}); !slices.Equal(got, []int{1}) {
+		t.Errorf("search after deleting = %v, want [1]", got)
+	}
+	if slices.Contains(idx.terms, "goodbye") {
+		t.Errorf("terms still contain goodbye after its only chirp was deleted")
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	socketHeartbeat          = 30 * time.Second
+	socketNotificationBuffer = 16
+
+	// Application close codes, sent when the access token stops being good enough
+	socketCloseTokenExpired = 4001
+	socketCloseUnauthorized = 4003
+)
+
+const (
+	socketChannelTimeline      = "timeline"
+	socketChannelUser          = "user"
+	socketChannelHashtag       = "hashtag"
+	socketChannelNotifications = "notifications"
+)
+
+// socketSession is a single authenticated WebSocket connection and the channels it subscribed to
+type socketSession struct {
+	conn   *wsConn
+	userId int
+
+	mu       sync.Mutex
+	token    string
+	channels map[string]streamFilter
+	notify   bool
+
+	notifications chan Notification
+	renewed       chan time.Time
+	kicked        chan socketKick
+}
+
+// socketKick is why a session is being disconnected, Code is the WebSocket close code it gets
+type socketKick struct {
+	Code   int
+	Reason string
+}
+
+func (s *socketSession) subscribed() (map[string]streamFilter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channels := make(map[string]streamFilter, len(s.channels))
+	for name, filter := range s.channels {
+		channels[name] = filter
+	}
+
+	return channels, s.notify
+}
+
+// kick asks the session to disconnect, only the first reason is kept
+func (s *socketSession) kick(code int, reason string) {
+	select {
+	case s.kicked <- socketKick{code, reason}:
+	default:
+	}
+}
+
+// socketHub knows every open session per user, so notifications and revocations can reach them
+type socketHub struct {
+	mu       sync.Mutex
+	sessions map[int]map[*socketSession]bool
+}
+
+func newSocketHub() *socketHub {
+	return &socketHub{sessions: map[int]map[*socketSession]bool{}}
+}
+
+func (h *socketHub) add(s *socketSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sessions[s.userId] == nil {
+		h.sessions[s.userId] = map[*socketSession]bool{}
+	}
+	h.sessions[s.userId][s] = true
+}
+
+func (h *socketHub) remove(s *socketSession) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.sessions[s.userId], s)
+	if len(h.sessions[s.userId]) == 0 {
+		delete(h.sessions, s.userId)
+	}
+}
+
+// disconnectUser closes every session of a user, used when their tokens are revoked
+func (h *socketHub) disconnectUser(userId int, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.sessions[userId] {
+		s.kick(socketCloseUnauthorized, reason)
+	}
+}
+
+// handleNotification is registered through onNotification, a session too busy to take it is disconnected
+func (h *socketHub) handleNotification(n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.sessions[n.UserId] {
+		select {
+		case s.notifications <- n:
+		default:
+			s.kick(wsCloseTryAgainLater, "Client is not keeping up")
+		}
+	}
+}
+
+type socketMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Hashtag string `json:"hashtag,omitempty"`
+	UserId  int    `json:"user_id,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// channelFilter turns a subscribe or unsubscribe message into the name of the channel and the chirps it carries
+func channelFilter(msg socketMessage) (string, streamFilter, error) {
+	switch msg.Channel {
+	case socketChannelTimeline:
+		return socketChannelTimeline, streamFilter{timeline: true}, nil
+	case socketChannelUser:
+		if msg.UserId < 1 {
+			return "", streamFilter{}, errors.New("user_id is required for the user channel")
+		}
+		userId := msg.UserId
+		return socketChannelUser + ":" + strconv.Itoa(userId), streamFilter{authorId: &userId}, nil
+	case socketChannelHashtag:
+		tag := normalizeHashtag(msg.Hashtag)
+		if tag == "" {
+			return "", streamFilter{}, errors.New("hashtag is required for the hashtag channel")
+		}
+		return socketChannelHashtag + ":" + tag, streamFilter{hashtag: tag}, nil
+	}
+
+	return "", streamFilter{}, fmt.Errorf("Unknown channel %q", msg.Channel)
+}
+
+func (cfg *apiConfig) sendSocket(s *socketSession, payload interface{}) error {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.writeText(dat)
+}
+
+func (cfg *apiConfig) sendSocketError(s *socketSession, msg string) error {
+	return cfg.sendSocket(s, struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}{"error", msg})
+}
+
+// handleSocketMessage acts on a single message from the client
+func (cfg *apiConfig) handleSocketMessage(s *socketSession, data []byte) error {
+	var msg socketMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return cfg.sendSocketError(s, "Messages must be JSON objects")
+	}
+
+	type channelResponse struct {
+		Type    string `json:"type"`
+		Channel string `json:"channel"`
+	}
+
+	switch msg.Type {
+	case "ping":
+		return cfg.sendSocket(s, struct {
+			Type string `json:"type"`
+		}{"pong"})
+
+	case "subscribe", "unsubscribe":
+		name := socketChannelNotifications
+		var filter streamFilter
+		if msg.Channel != socketChannelNotifications {
+			var err error
+			name, filter, err = channelFilter(msg)
+			if err != nil {
+				return cfg.sendSocketError(s, err.Error())
+			}
+		}
+
+		// Following a user live is looking at their profile, which users who blocked the viewer keep to themselves
+		if msg.Type == "subscribe" && filter.authorId != nil {
+			author, ok := cfg.database.getUser(*filter.authorId)
+			if !ok || author.DeletedAt != nil || cfg.database.hasBlocked(author.Id, s.userId) {
+				return cfg.sendSocketError(s, "User does not exist")
+			}
+		}
+
+		s.mu.Lock()
+		switch {
+		case name == socketChannelNotifications:
+			s.notify = msg.Type == "subscribe"
+		case msg.Type == "subscribe":
+			s.channels[name] = filter
+		default:
+			delete(s.channels, name)
+		}
+		s.mu.Unlock()
+
+		return cfg.sendSocket(s, channelResponse{msg.Type + "d", name})
+
+	case "auth":
+		// Swapping in a fresh access token keeps the connection open past the expiry of the first one
+		user, token, err := cfg.authenticateToken(msg.Token)
+		if err != nil || user.Id != s.userId {
+			return cfg.sendSocketError(s, "Token is not valid for this connection")
+		}
+
+		expiresAt, err := token.Claims.GetExpirationTime()
+		if err != nil || expiresAt == nil {
+			return cfg.sendSocketError(s, "Token does not expire")
+		}
+
+		s.mu.Lock()
+		s.token = msg.Token
+		s.mu.Unlock()
+		select {
+		case <-s.renewed:
+		default:
+		}
+		s.renewed <- expiresAt.Time
+
+		return cfg.sendSocket(s, struct {
+			Type      string    `json:"type"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}{"authenticated", expiresAt.Time})
+	}
+
+	return cfg.sendSocketError(s, fmt.Sprintf("Unknown message type %q", msg.Type))
+}
+
+// sendSocketChirp forwards a chirp event to the session once, listing every subscribed channel it matches
+func (cfg *apiConfig) sendSocketChirp(s *socketSession, viewer *User, event streamEvent) error {
+	channels, _ := s.subscribed()
+	matched := []string{}
+	for name, filter := range channels {
+		if cfg.streamMatches(filter, viewer, event.Chirp) {
+			matched = append(matched, name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	slices.Sort(matched)
+
+	type chirpMessage struct {
+		Type     string         `json:"type"`
+		Event    string         `json:"event"`
+		Channels []string       `json:"channels"`
+		ChirpId  int            `json:"chirp_id"`
+		Chirp    *chirpResponse `json:"chirp,omitempty"`
+	}
+
+	msg := chirpMessage{Type: "chirp", Event: event.Kind, Channels: matched, ChirpId: event.Chirp.Id}
+	if event.Kind == chirpCreated {
+		chirp, ok := cfg.database.getChirp(event.Chirp.Id)
+		if !ok || !cfg.inFeed(viewer, chirp) {
+			return nil
+		}
+		resp := cfg.newChirpResponse(viewer, chirp)
+		msg.Chirp = &resp
+	} else if !cfg.inFeed(viewer, event.Chirp) {
+		// Only the id is sent for other events, but even that is not for viewers who could never see the chirp
+		return nil
+	}
+
+	return cfg.sendSocket(s, msg)
+}
+
+// handlerSocket serves the WebSocket API. Browsers cannot set headers on WebSocket requests, so the
+// access token may also be passed as the access_token query parameter
+func (cfg *apiConfig) handlerSocket(w http.ResponseWriter, r *http.Request) {
+	bearer, err := getBearerToken(r.Header)
+	if err != nil {
+		bearer = r.URL.Query().Get("access_token")
+	}
+	if bearer == "" {
+		respondWithAuthError(w, errMissingToken)
+		return
+	}
+
+	user, token, err := cfg.authenticateToken(bearer)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	expiresAt, err := token.Claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		respondWithAuthError(w, errInvalidToken)
+		return
+	}
+
+	conn, ok := upgradeWebSocket(w, r)
+	if !ok {
+		return
+	}
+
+	s := &socketSession{
+		conn:          conn,
+		userId:        user.Id,
+		token:         bearer,
+		channels:      map[string]streamFilter{},
+		notifications: make(chan Notification, socketNotificationBuffer),
+		renewed:       make(chan time.Time, 1),
+		kicked:        make(chan socketKick, 1),
+	}
+	cfg.sockets.add(s)
+	defer cfg.sockets.remove(s)
+
+	sub, _ := cfg.stream.subscribe(0)
+	defer cfg.stream.unsubscribe(sub)
+
+	readErrs := make(chan error, 1)
+	go func() {
+		for {
+			data, err := conn.readMessage(2 * socketHeartbeat)
+			if err == nil {
+				err = cfg.handleSocketMessage(s, data)
+			}
+			if err != nil {
+				readErrs <- err
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(socketHeartbeat)
+	defer heartbeat.Stop()
+	expiry := time.NewTimer(time.Until(expiresAt.Time))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case err := <-readErrs:
+			switch {
+			case errors.Is(err, errWsClosed):
+				conn.conn.Close()
+			case errors.Is(err, errWsTooBig):
+				conn.close(wsCloseTooBig, "Message too big")
+			case errors.Is(err, errWsBinary):
+				conn.close(wsCloseUnsupported, "Only text messages are supported")
+			case errors.Is(err, errWsProtocol):
+				conn.close(wsCloseProtocolError, "Protocol error")
+			default:
+				conn.close(wsCloseGoingAway, "")
+			}
+			return
+
+		case kick := <-s.kicked:
+			conn.close(kick.Code, kick.Reason)
+			return
+
+		case at := <-s.renewed:
+			expiry.Reset(time.Until(at))
+
+		case <-expiry.C:
+			conn.close(socketCloseTokenExpired, "Access token expired")
+			return
+
+		case <-heartbeat.C:
+			// Catches revocations, suspensions and deletions that happened while connected
+			s.mu.Lock()
+			bearer := s.token
+			s.mu.Unlock()
+			if _, _, err := cfg.authenticateToken(bearer); err != nil {
+				conn.close(socketCloseUnauthorized, err.Error())
+				return
+			}
+			if err := conn.ping(); err != nil {
+				conn.conn.Close()
+				return
+			}
+
+		case event, ok := <-sub.events:
+			if !ok {
+				conn.close(wsCloseTryAgainLater, "Client is not keeping up")
+				return
+			}
+			viewer, ok := cfg.database.getUser(s.userId)
+			if !ok {
+				conn.close(socketCloseUnauthorized, errUnknownUser.Error())
+				return
+			}
+			if err := cfg.sendSocketChirp(s, &viewer, event); err != nil {
+				conn.conn.Close()
+				return
+			}
+
+		case notification := <-s.notifications:
+			if _, notify := s.subscribed(); !notify {
+				continue
+			}
+			viewer, ok := cfg.database.getUser(s.userId)
+			if !ok || !cfg.notificationVisible(viewer, notification) {
+				continue
+			}
+			err := cfg.sendSocket(s, struct {
+				Type         string               `json:"type"`
+				Notification notificationResponse `json:"notification"`
+			}{"notification", cfg.newNotificationResponse(notification)})
+			if err != nil {
+				conn.conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"container/heap"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"sync"
+	"time"
 )
 
 func FreshNewDb() *Database {
@@ -35,18 +40,170 @@ func (d *Database) loadDatabase() error {
 		return errors.New("failed to read database")
 	}
 
+	// Ids are never reused, even when the latest record has been deleted, so the persisted counters win;
+	// older database files without them fall back to the last stored record
 	id := len(d.Chirps)
 	if id != 0 {
-		d.latestChirpId = d.Chirps[len(d.Chirps)-1].Id
+		d.LatestChirpId = max(d.LatestChirpId, d.Chirps[len(d.Chirps)-1].Id)
 	}
 	id = len(d.Users)
 	if id != 0 {
-		d.latestUserId = d.Users[len(d.Users)-1].Id
+		d.LatestUserId = max(d.LatestUserId, d.Users[len(d.Users)-1].Id)
+	}
+	id = len(d.AuditLog)
+	if id != 0 {
+		d.LatestAuditId = max(d.LatestAuditId, d.AuditLog[len(d.AuditLog)-1].Id)
+	}
+	id = len(d.Reports)
+	if id != 0 {
+		d.LatestReportId = max(d.LatestReportId, d.Reports[len(d.Reports)-1].Id)
+	}
+	id = len(d.ModerationDecisions)
+	if id != 0 {
+		d.LatestModerationDecisionId = max(d.LatestModerationDecisionId, d.ModerationDecisions[len(d.ModerationDecisions)-1].Id)
+	}
+	id = len(d.Notifications)
+	if id != 0 {
+		d.LatestNotificationId = max(d.LatestNotificationId, d.Notifications[len(d.Notifications)-1].Id)
 	}
 
+	// Chirps written before visibility levels existed were public to everyone
+	for i := range d.Chirps {
+		if d.Chirps[i].Visibility == "" {
+			d.Chirps[i].Visibility = visibilityPublic
+		}
+	}
+
+	d.rebuildIndexes()
+
 	return nil
 }
 
+// rebuildIndexes recreates the in-memory lookups that are derived from the stored records
+func (d *Database) rebuildIndexes() {
+	d.replies = map[int][]int{}
+	d.chirpsByAuthor = map[int][]int{}
+	d.rechirps = map[int][]int{}
+	d.quotes = map[int][]int{}
+	d.hashtags = map[string][]int{}
+	d.mentions = map[int][]int{}
+	for _, chirp := range d.Chirps {
+		d.indexChirp(chirp)
+	}
+	for id, parentId := range d.DeletedReplies {
+		addToIndex(d.replies, parentId, id)
+	}
+
+	d.notifications = map[int][]int{}
+	for _, notification := range d.Notifications {
+		d.notifications[notification.UserId] = append(d.notifications[notification.UserId], notification.Id)
+	}
+
+	d.usernames = map[string]int{}
+	d.userTerms = nil
+	for _, user := range d.Users {
+		if user.Username != "" {
+			d.usernames[normalizeUsername(user.Username)] = user.Id
+		}
+		d.indexUserTerms(user)
+	}
+
+	d.blocks = map[int]map[int]bool{}
+	for _, block := range d.Blocks {
+		if d.blocks[block.BlockerId] == nil {
+			d.blocks[block.BlockerId] = map[int]bool{}
+		}
+		d.blocks[block.BlockerId][block.BlockedId] = true
+	}
+
+	d.mutes = map[int]map[int]bool{}
+	for _, mute := range d.Mutes {
+		if d.mutes[mute.MuterId] == nil {
+			d.mutes[mute.MuterId] = map[int]bool{}
+		}
+		d.mutes[mute.MuterId][mute.MutedId] = true
+	}
+
+	d.likes = map[int]map[int]bool{}
+	for _, like := range d.Likes {
+		if d.likes[like.ChirpId] == nil {
+			d.likes[like.ChirpId] = map[int]bool{}
+		}
+		d.likes[like.ChirpId][like.UserId] = true
+	}
+
+	d.following = map[int]map[int]bool{}
+	d.followers = map[int]map[int]bool{}
+	for _, follow := range d.Follows {
+		d.indexFollow(follow)
+	}
+}
+
+// addToIndex inserts id into the sorted list kept under key, edited chirps are indexed again
+// so the id being added is not necessarily the largest one
+func addToIndex[K comparable](index map[K][]int, key K, id int) {
+	ids := index[key]
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		index[key] = slices.Insert(ids, i, id)
+	}
+}
+
+func removeFromIndex[K comparable](index map[K][]int, key K, id int) {
+	ids := index[key]
+	i, found := slices.BinarySearch(ids, id)
+	if !found {
+		return
+	}
+
+	ids = slices.Delete(ids, i, i+1)
+	if len(ids) == 0 {
+		delete(index, key)
+	} else {
+		index[key] = ids
+	}
+}
+
+// indexChirp adds a stored chirp to the in-memory lookups, d.mu must be held
+func (d *Database) indexChirp(c Chirp) {
+	addToIndex(d.chirpsByAuthor, c.AuthorId, c.Id)
+	if c.InReplyTo != nil {
+		addToIndex(d.replies, *c.InReplyTo, c.Id)
+	}
+	if c.RechirpOf != nil {
+		addToIndex(d.rechirps, *c.RechirpOf, c.Id)
+	}
+	if c.QuoteOf != nil {
+		addToIndex(d.quotes, *c.QuoteOf, c.Id)
+	}
+	for _, tag := range chirpHashtags(c.Body) {
+		addToIndex(d.hashtags, tag, c.Id)
+	}
+	for _, userId := range c.MentionIds {
+		addToIndex(d.mentions, userId, c.Id)
+	}
+}
+
+// unindexChirp removes a chirp from the in-memory lookups, d.mu must be held
+func (d *Database) unindexChirp(c Chirp) {
+	removeFromIndex(d.chirpsByAuthor, c.AuthorId, c.Id)
+	if c.InReplyTo != nil {
+		removeFromIndex(d.replies, *c.InReplyTo, c.Id)
+	}
+	if c.RechirpOf != nil {
+		removeFromIndex(d.rechirps, *c.RechirpOf, c.Id)
+	}
+	if c.QuoteOf != nil {
+		removeFromIndex(d.quotes, *c.QuoteOf, c.Id)
+	}
+	for _, tag := range chirpHashtags(c.Body) {
+		removeFromIndex(d.hashtags, tag, c.Id)
+	}
+	for _, userId := range c.MentionIds {
+		removeFromIndex(d.mentions, userId, c.Id)
+	}
+}
+
 func (d *Database) sync() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -63,74 +220,1282 @@ func (d *Database) sync() error {
 	return nil
 }
 
+// chirpIndex finds the position of a chirp, Chirps is always sorted by id as ids only ever grow
+func (d *Database) chirpIndex(id int) (int, bool) {
+	return slices.BinarySearchFunc(d.Chirps, id, func(c Chirp, id int) int {
+		return c.Id - id
+	})
+}
+
+func (d *Database) userIndex(id int) (int, bool) {
+	return slices.BinarySearchFunc(d.Users, id, func(u User, id int) int {
+		return u.Id - id
+	})
+}
+
 func (d *Database) storeChirp(c Chirp) (Chirp, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	if c.Id == 0 {
-		d.latestChirpId++
-		c.Id = d.latestChirpId
-		d.Chirps = append(d.Chirps, c)
+		c = d.insertChirpLocked(c)
 	} else {
-		d.Chirps[c.Id-1] = c
+		i, ok := d.chirpIndex(c.Id)
+		if !ok {
+			return Chirp{}, errors.New("chirp does not exist")
+		}
+		d.replaceChirpLocked(i, c)
 	}
 
 	return c, nil
 }
 
+// insertChirpLocked adds a new chirp and tells the listeners, d.mu must be held
+func (d *Database) insertChirpLocked(c Chirp) Chirp {
+	d.LatestChirpId++
+	c.Id = d.LatestChirpId
+	c.AttachmentIds = d.claimAttachmentsLocked(c)
+	d.Chirps = append(d.Chirps, c)
+	d.indexChirp(c)
+	d.emitChirpEvent(chirpEvent{Kind: chirpCreated, Chirp: c})
+
+	return c
+}
+
+// replaceChirpLocked swaps the chirp at i for c and tells the listeners, d.mu must be held
+func (d *Database) replaceChirpLocked(i int, c Chirp) {
+	previous := d.Chirps[i]
+	d.unindexChirp(previous)
+	d.Chirps[i] = c
+	d.indexChirp(c)
+	d.emitChirpEvent(chirpEvent{Kind: chirpUpdated, Chirp: c, Previous: &previous})
+}
+
+// onChirpEvent registers fn to be told about every chirp that is created, updated or deleted.
+// fn runs while d.mu is held, so it must be quick and must not call back into the database
+func (d *Database) onChirpEvent(fn func(chirpEvent)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.chirpListeners = append(d.chirpListeners, fn)
+}
+
+// emitChirpEvent tells every listener about a chirp change, d.mu must be held
+func (d *Database) emitChirpEvent(e chirpEvent) {
+	for _, fn := range d.chirpListeners {
+		fn(e)
+	}
+}
+
 func (d *Database) storeUser(u User) (User, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	return d.storeUserLocked(u)
+}
+
+// storeNewUser stores a newly registered user, who is made admin when registering with bootstrapEmail
+// while there is no admin yet. Checking and storing under one lock keeps a second admin from slipping in
+func (d *Database) storeNewUser(u User, bootstrapEmail string) (User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hasAdmin := slices.ContainsFunc(d.Users, func(user User) bool {
+		return user.Role == roleAdmin
+	})
+	if bootstrapEmail != "" && u.Email == bootstrapEmail && !hasAdmin {
+		u.Role = roleAdmin
+	}
+
+	return d.storeUserLocked(u)
+}
+
+// storeUserLocked inserts or replaces a user, d.mu must be held
+func (d *Database) storeUserLocked(u User) (User, error) {
+	username := normalizeUsername(u.Username)
+	if id, ok := d.usernames[username]; ok && username != "" && id != u.Id {
+		return User{}, errUsernameTaken
+	}
+
 	if u.Id == 0 {
-		d.latestUserId++
-		u.Id = d.latestUserId
+		d.LatestUserId++
+		u.Id = d.LatestUserId
 		d.Users = append(d.Users, u)
 	} else {
-		d.Users[u.Id-1] = u
+		i, ok := d.userIndex(u.Id)
+		if !ok {
+			return User{}, errors.New("user does not exist")
+		}
+		delete(d.usernames, normalizeUsername(d.Users[i].Username))
+		d.unindexUserTerms(d.Users[i])
+		d.Users[i] = u
+	}
+	d.indexUserTerms(u)
+
+	if username != "" {
+		d.usernames[username] = u.Id
 	}
 
 	return u, nil
 }
 
-func (d *Database) deleteChirp(c Chirp) error {
+// updateUser applies update to the stored user under the lock, so only the fields update sets change and
+// whatever else was written to the user in the meantime stays. Like onChirpEvent update must not call back
+// into the database, when it returns an error the user is left as it was
+func (d *Database) updateUser(userId int, update func(u *User) error) (User, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if len(d.Chirps) == 0 {
-		return nil
+	i, ok := d.userIndex(userId)
+	if !ok {
+		return User{}, errors.New("user does not exist")
+	}
+
+	u := d.Users[i]
+	if err := update(&u); err != nil {
+		return User{}, err
 	}
 
-	if len(d.Chirps) == 1 {
-		if d.Chirps[0].Id == c.Id {
-			d.Chirps = []Chirp{}
-			d.latestChirpId = 0
+	return d.storeUserLocked(u)
+}
+
+// setSuspended suspends or reinstates a user, suspending also ends every session of the user
+func (d *Database) setSuspended(userId int, suspended bool) (User, error) {
+	return d.updateUser(userId, func(u *User) error {
+		u.Suspended = suspended
+		if suspended {
+			revokeSessions(u)
 		}
 		return nil
+	})
+}
+
+// updateNotificationPreferences merges changes into the notification preferences of a user, in place so
+// that other updates to the user made at the same time are not lost
+func (d *Database) updateNotificationPreferences(userId int, changes map[string]bool) (User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.userIndex(userId)
+	if !ok {
+		return User{}, errors.New("user does not exist")
+	}
+
+	preferences := maps.Clone(d.Users[i].NotificationPreferences)
+	if preferences == nil {
+		preferences = map[string]bool{}
 	}
+	maps.Copy(preferences, changes)
+	d.Users[i].NotificationPreferences = preferences
 
-	// The c.Id is it's position within the Chirps, but as arrays and slices use offsets rather than "first item";
-	// The real position is Id-1
-	// Per this logic, left contains all elements BEFORE c, and right contains all elements AFTER
-	left := d.Chirps[:c.Id-1]
-	right := d.Chirps[c.Id-2:]
+	return d.Users[i], nil
+}
 
-	d.Chirps = left
-	d.latestChirpId = len(d.Chirps) - 1
+// setProfileImage points the avatar or banner of a user at key and returns the key it replaced, in place
+// like updateNotificationPreferences so a concurrent upload or profile edit is not undone
+func (d *Database) setProfileImage(userId int, kind, key string) (User, string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	// Re-insert the right chirps to re-order ids, this removes all holes
-	for _, chirp := range right {
-		chirp.Id = 0
-		d.storeChirp(chirp)
+	i, ok := d.userIndex(userId)
+	if !ok {
+		return User{}, "", errors.New("user does not exist")
 	}
 
-	return nil
+	field := d.Users[i].profileImageKey(kind)
+	previous := *field
+	*field = key
+
+	return d.Users[i], previous, nil
 }
 
-type Database struct {
-	Chirps        []Chirp `json:"chirps"`
-	latestChirpId int
-	Users         []User `json:"users"`
-	latestUserId  int
-	mu            sync.Mutex
+// getUserByProfileImageKey finds the user whose current avatar or banner is stored at key
+func (d *Database) getUserByProfileImageKey(key string) (User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, u := range d.Users {
+		if u.AvatarKey == key || u.BannerKey == key {
+			return u, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (d *Database) getUserByUsername(username string) (User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id, ok := d.usernames[normalizeUsername(username)]
+	if !ok {
+		return User{}, false
+	}
+
+	i, ok := d.userIndex(id)
+	if !ok {
+		return User{}, false
+	}
+
+	return d.Users[i], true
+}
+
+func (d *Database) storeNotification(n Notification) (Notification, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.LatestNotificationId++
+	n.Id = d.LatestNotificationId
+	d.Notifications = append(d.Notifications, n)
+	d.notifications[n.UserId] = append(d.notifications[n.UserId], n.Id)
+	for _, fn := range d.notificationListeners {
+		fn(n)
+	}
+
+	return n, nil
+}
+
+// storeReport adds a report, every user can only report a chirp once
+func (d *Database) storeReport(r Report) (Report, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, report := range d.Reports {
+		if report.ChirpId == r.ChirpId && report.ReporterId == r.ReporterId {
+			return Report{}, errAlreadyReported
+		}
+	}
+
+	d.LatestReportId++
+	r.Id = d.LatestReportId
+	d.Reports = append(d.Reports, r)
+
+	return r, nil
+}
+
+func (d *Database) listReports() []Report {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Reports)
+}
+
+func (d *Database) countOpenReports(chirpId int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.countOpenReportsLocked(chirpId)
+}
+
+func (d *Database) countOpenReportsLocked(chirpId int) int {
+	count := 0
+	for _, report := range d.Reports {
+		if report.ChirpId == chirpId && report.DecisionId == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+// updateChirp applies update to the stored chirp under the lock like updateUser does for users, so an edit
+// made by the author in the meantime stays. update must not call back into the database
+func (d *Database) updateChirp(chirpId int, update func(c *Chirp)) (Chirp, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.chirpIndex(chirpId)
+	if !ok {
+		return Chirp{}, errors.New("chirp does not exist")
+	}
+
+	chirp := d.Chirps[i]
+	update(&chirp)
+	d.replaceChirpLocked(i, chirp)
+
+	return chirp, nil
+}
+
+// setChirpModeration sets whether a chirp is hidden and flagged for review
+func (d *Database) setChirpModeration(chirpId int, hidden, flagged bool) (Chirp, error) {
+	return d.updateChirp(chirpId, func(c *Chirp) {
+		c.Hidden = hidden
+		c.Flagged = flagged
+	})
+}
+
+// autoHideChirp hides a chirp once it has threshold open reports and records the auto_hide decision.
+// Counting and hiding under one lock makes sure concurrent reports hide it and record the decision only once
+func (d *Database) autoHideChirp(chirpId, threshold int) (ModerationDecision, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.chirpIndex(chirpId)
+	if !ok || d.Chirps[i].Hidden {
+		return ModerationDecision{}, false
+	}
+	reports := d.countOpenReportsLocked(chirpId)
+	if reports < threshold {
+		return ModerationDecision{}, false
+	}
+
+	chirp := d.Chirps[i]
+	chirp.Hidden = true
+	d.replaceChirpLocked(i, chirp)
+
+	decision := ModerationDecision{
+		ChirpId:   chirp.Id,
+		AuthorId:  chirp.AuthorId,
+		Action:    moderationAutoHide,
+		Note:      fmt.Sprintf("%d open reports", reports),
+		CreatedAt: time.Now().UTC(),
+	}
+	return d.storeModerationDecisionLocked(decision, false), true
+}
+
+// storeModerationDecision records a decision, with settleReports every open report on the chirp is settled by it
+func (d *Database) storeModerationDecision(m ModerationDecision, settleReports bool) (ModerationDecision, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.storeModerationDecisionLocked(m, settleReports), nil
+}
+
+func (d *Database) storeModerationDecisionLocked(m ModerationDecision, settleReports bool) ModerationDecision {
+	d.LatestModerationDecisionId++
+	m.Id = d.LatestModerationDecisionId
+	m.ReportIds = []int{}
+	if settleReports {
+		for i, report := range d.Reports {
+			if report.ChirpId == m.ChirpId && report.DecisionId == nil {
+				d.Reports[i].DecisionId = &m.Id
+				m.ReportIds = append(m.ReportIds, report.Id)
+			}
+		}
+	}
+	d.ModerationDecisions = append(d.ModerationDecisions, m)
+
+	return m
+}
+
+func (d *Database) listModerationDecisions() []ModerationDecision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.ModerationDecisions)
+}
+
+// onNotification registers fn to be told about every new notification, like onChirpEvent fn runs while d.mu is held
+func (d *Database) onNotification(fn func(Notification)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.notificationListeners = append(d.notificationListeners, fn)
+}
+
+func (d *Database) notificationIndex(id int) (int, bool) {
+	return slices.BinarySearchFunc(d.Notifications, id, func(n Notification, id int) int {
+		return n.Id - id
+	})
+}
+
+// listNotifications returns every notification of a user, oldest first
+func (d *Database) listNotifications(userId int) []Notification {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	notifications := make([]Notification, 0, len(d.notifications[userId]))
+	for _, id := range d.notifications[userId] {
+		if i, ok := d.notificationIndex(id); ok {
+			notifications = append(notifications, d.Notifications[i])
+		}
+	}
+
+	return notifications
+}
+
+// markNotificationsRead marks the notifications of a user with ids in [fromId, toId] as read,
+// reporting whether any notification of the user fell in that range
+func (d *Database) markNotificationsRead(userId, fromId, toId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	found := false
+	for _, id := range d.notifications[userId] {
+		if id < fromId || id > toId {
+			continue
+		}
+		if i, ok := d.notificationIndex(id); ok {
+			d.Notifications[i].Read = true
+			found = true
+		}
+	}
+
+	return found
+}
+
+// deleteNotificationsLocked removes every notification matching fn while d.mu is held
+func (d *Database) deleteNotificationsLocked(fn func(Notification) bool) {
+	for _, notification := range d.Notifications {
+		if fn(notification) {
+			removeFromIndex(d.notifications, notification.UserId, notification.Id)
+		}
+	}
+	d.Notifications = slices.DeleteFunc(d.Notifications, fn)
+}
+
+// editChirp stores the body, mentions and review flags of edited as a new version of the chirp, edited can
+// hide a chirp but not bring a hidden one back
+func (d *Database) editChirp(edited Chirp) (Chirp, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := edited.Id
+	i, ok := d.chirpIndex(id)
+	if !ok {
+		return Chirp{}, errors.New("chirp does not exist")
+	}
+	chirp := d.Chirps[i]
+
+	writtenAt := chirp.CreatedAt
+	if chirp.EditedAt != nil {
+		writtenAt = *chirp.EditedAt
+	}
+
+	version := 1
+	for _, v := range d.ChirpVersions {
+		if v.ChirpId == id {
+			version++
+		}
+	}
+	d.ChirpVersions = append(d.ChirpVersions, ChirpVersion{
+		ChirpId:   id,
+		Version:   version,
+		Body:      chirp.Body,
+		CreatedAt: writtenAt,
+	})
+
+	previous := chirp
+	d.unindexChirp(previous)
+	now := time.Now().UTC()
+	chirp.Body = edited.Body
+	chirp.MentionIds = edited.MentionIds
+	// A chirp still hidden in the moderation queue stays there, an edit must not sneak it back out
+	chirp.Flagged = edited.Flagged || chirp.Hidden && chirp.Flagged
+	chirp.Hidden = chirp.Hidden || edited.Hidden
+	chirp.EditedAt = &now
+	d.Chirps[i] = chirp
+	d.indexChirp(chirp)
+	d.emitChirpEvent(chirpEvent{Kind: chirpUpdated, Chirp: chirp, Previous: &previous})
+
+	return chirp, nil
+}
+
+func (d *Database) listChirpVersions(chirpId int) []ChirpVersion {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	versions := []ChirpVersion{}
+	for _, v := range d.ChirpVersions {
+		if v.ChirpId == chirpId {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions
+}
+
+// getReplyIds lists the replies to a chirp, deleted replies that still have replies of their own included
+func (d *Database) getReplyIds(chirpId int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.replies[chirpId])
+}
+
+// getParentId finds the chirp a reply answers, for deleted replies that still have replies of their own too
+func (d *Database) getParentId(chirpId int) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if i, ok := d.chirpIndex(chirpId); ok {
+		if d.Chirps[i].InReplyTo == nil {
+			return 0, false
+		}
+		return *d.Chirps[i].InReplyTo, true
+	}
+
+	parentId, ok := d.DeletedReplies[chirpId]
+	return parentId, ok
+}
+
+// getReplies lists the direct replies to a chirp, oldest first
+func (d *Database) getReplies(chirpId int) []Chirp {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	replies := []Chirp{}
+	for _, id := range d.replies[chirpId] {
+		if i, ok := d.chirpIndex(id); ok {
+			replies = append(replies, d.Chirps[i])
+		}
+	}
+
+	return replies
+}
+
+// countRechirps returns how often a chirp has been rechirped and quoted
+func (d *Database) countRechirps(chirpId int) (rechirps int, quotes int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.rechirps[chirpId]), len(d.quotes[chirpId])
+}
+
+// findRechirp finds the rechirp userId made of chirpId, if any
+func (d *Database) findRechirp(userId, chirpId int) (Chirp, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.findRechirpLocked(userId, chirpId)
+}
+
+// storeRechirp stores c unless its author already rechirped the same chirp, in which case that rechirp is
+// handed back and created is false. Checking and storing under one lock keeps concurrent requests from
+// rechirping twice
+func (d *Database) storeRechirp(c Chirp) (chirp Chirp, created bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if existing, ok := d.findRechirpLocked(c.AuthorId, *c.RechirpOf); ok {
+		return existing, false
+	}
+
+	return d.insertChirpLocked(c), true
+}
+
+func (d *Database) findRechirpLocked(userId, chirpId int) (Chirp, bool) {
+	for _, id := range d.rechirps[chirpId] {
+		i, ok := d.chirpIndex(id)
+		if ok && d.Chirps[i].AuthorId == userId {
+			return d.Chirps[i], true
+		}
+	}
+
+	return Chirp{}, false
+}
+
+// listMentionChirps returns the ids of every chirp mentioning a user, oldest first
+func (d *Database) listMentionChirps(userId int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.mentions[userId])
+}
+
+// listHashtagChirps returns the ids of every chirp using a normalized tag, oldest first
+func (d *Database) listHashtagChirps(tag string) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.hashtags[tag])
+}
+
+// indexFollow adds a follow to the in-memory lookups, d.mu must be held
+func (d *Database) indexFollow(f Follow) {
+	if d.following[f.FollowerId] == nil {
+		d.following[f.FollowerId] = map[int]bool{}
+	}
+	d.following[f.FollowerId][f.FolloweeId] = true
+
+	if d.followers[f.FolloweeId] == nil {
+		d.followers[f.FolloweeId] = map[int]bool{}
+	}
+	d.followers[f.FolloweeId][f.FollowerId] = true
+}
+
+// unindexFollow removes a follow from the in-memory lookups, d.mu must be held
+func (d *Database) unindexFollow(f Follow) {
+	delete(d.following[f.FollowerId], f.FolloweeId)
+	delete(d.followers[f.FolloweeId], f.FollowerId)
+}
+
+// storeFollow records that followerId follows followeeId, following twice is a no-op reported by the returned bool
+func (d *Database) storeFollow(followerId, followeeId int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.following[followerId][followeeId] {
+		return false, nil
+	}
+
+	follow := Follow{FollowerId: followerId, FolloweeId: followeeId, CreatedAt: time.Now().UTC()}
+	d.Follows = append(d.Follows, follow)
+	d.indexFollow(follow)
+
+	return true, nil
+}
+
+func (d *Database) deleteFollow(followerId, followeeId int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.following[followerId][followeeId] {
+		return nil
+	}
+
+	d.Follows = slices.DeleteFunc(d.Follows, func(f Follow) bool {
+		return f.FollowerId == followerId && f.FolloweeId == followeeId
+	})
+	d.unindexFollow(Follow{FollowerId: followerId, FolloweeId: followeeId})
+
+	return nil
+}
+
+// storeBlock records that blockerId blocks blockedId and drops the follows between them both ways,
+// blocking twice is a no-op reported by the returned bool
+func (d *Database) storeBlock(blockerId, blockedId int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.blocks[blockerId][blockedId] {
+		return false, nil
+	}
+
+	d.Blocks = append(d.Blocks, Block{BlockerId: blockerId, BlockedId: blockedId, CreatedAt: time.Now().UTC()})
+	if d.blocks[blockerId] == nil {
+		d.blocks[blockerId] = map[int]bool{}
+	}
+	d.blocks[blockerId][blockedId] = true
+
+	d.Follows = slices.DeleteFunc(d.Follows, func(f Follow) bool {
+		return (f.FollowerId == blockerId && f.FolloweeId == blockedId) || (f.FollowerId == blockedId && f.FolloweeId == blockerId)
+	})
+	d.unindexFollow(Follow{FollowerId: blockerId, FolloweeId: blockedId})
+	d.unindexFollow(Follow{FollowerId: blockedId, FolloweeId: blockerId})
+
+	return true, nil
+}
+
+func (d *Database) deleteBlock(blockerId, blockedId int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Blocks = slices.DeleteFunc(d.Blocks, func(b Block) bool {
+		return b.BlockerId == blockerId && b.BlockedId == blockedId
+	})
+	delete(d.blocks[blockerId], blockedId)
+
+	return nil
+}
+
+// isBlocked reports whether either of the two users blocks the other
+func (d *Database) isBlocked(userId, otherId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.blocks[userId][otherId] || d.blocks[otherId][userId]
+}
+
+func (d *Database) hasBlocked(blockerId, blockedId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.blocks[blockerId][blockedId]
+}
+
+// listBlocks returns who a user blocks, oldest first
+func (d *Database) listBlocks(blockerId int) []Block {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	blocks := []Block{}
+	for _, block := range d.Blocks {
+		if block.BlockerId == blockerId {
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks
+}
+
+func (d *Database) storeMute(muterId, mutedId int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.mutes[muterId][mutedId] {
+		return false, nil
+	}
+
+	d.Mutes = append(d.Mutes, Mute{MuterId: muterId, MutedId: mutedId, CreatedAt: time.Now().UTC()})
+	if d.mutes[muterId] == nil {
+		d.mutes[muterId] = map[int]bool{}
+	}
+	d.mutes[muterId][mutedId] = true
+
+	return true, nil
+}
+
+func (d *Database) deleteMute(muterId, mutedId int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Mutes = slices.DeleteFunc(d.Mutes, func(m Mute) bool {
+		return m.MuterId == muterId && m.MutedId == mutedId
+	})
+	delete(d.mutes[muterId], mutedId)
+
+	return nil
+}
+
+func (d *Database) hasMuted(muterId, mutedId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.mutes[muterId][mutedId]
+}
+
+// listMutes returns who a user mutes, oldest first
+func (d *Database) listMutes(muterId int) []Mute {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mutes := []Mute{}
+	for _, mute := range d.Mutes {
+		if mute.MuterId == muterId {
+			mutes = append(mutes, mute)
+		}
+	}
+
+	return mutes
+}
+
+func (d *Database) isFollowing(followerId, followeeId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.following[followerId][followeeId]
+}
+
+func (d *Database) countFollows(userId int) (followers int, following int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.followers[userId]), len(d.following[userId])
+}
+
+// listFollows returns the follows of or on a user, newest first
+func (d *Database) listFollows(userId int, ofFollowers bool) []Follow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	follows := []Follow{}
+	for i := len(d.Follows) - 1; i >= 0; i-- {
+		f := d.Follows[i]
+		if (ofFollowers && f.FolloweeId == userId) || (!ofFollowers && f.FollowerId == userId) {
+			follows = append(follows, f)
+		}
+	}
+
+	return follows
+}
+
+func (d *Database) listFollowing(userId int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ids := make([]int, 0, len(d.following[userId]))
+	for id := range d.following[userId] {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// storeLike records that userId likes chirpId, liking twice is a no-op reported by the returned bool
+func (d *Database) storeLike(userId, chirpId int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.likes[chirpId][userId] {
+		return false, nil
+	}
+
+	if _, ok := d.chirpIndex(chirpId); !ok {
+		return false, errors.New("chirp does not exist")
+	}
+
+	d.Likes = append(d.Likes, Like{UserId: userId, ChirpId: chirpId, CreatedAt: time.Now().UTC()})
+	if d.likes[chirpId] == nil {
+		d.likes[chirpId] = map[int]bool{}
+	}
+	d.likes[chirpId][userId] = true
+
+	return true, nil
+}
+
+func (d *Database) deleteLike(userId, chirpId int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.likes[chirpId][userId] {
+		return nil
+	}
+
+	d.Likes = slices.DeleteFunc(d.Likes, func(l Like) bool {
+		return l.UserId == userId && l.ChirpId == chirpId
+	})
+	delete(d.likes[chirpId], userId)
+
+	return nil
+}
+
+func (d *Database) countLikes(chirpId int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.likes[chirpId])
+}
+
+func (d *Database) hasLiked(userId, chirpId int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.likes[chirpId][userId]
+}
+
+// listUserLikes returns the likes of a user, newest first
+func (d *Database) listUserLikes(userId int) []Like {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	likes := []Like{}
+	for i := len(d.Likes) - 1; i >= 0; i-- {
+		if d.Likes[i].UserId == userId {
+			likes = append(likes, d.Likes[i])
+		}
+	}
+
+	return likes
+}
+
+// walkAuthorsChirps visits the chirps of every given author newest first until yield returns false,
+// d.mu is only held while finding the next chirp so yield is free to use the database
+func (d *Database) walkAuthorsChirps(authorIds []int, yield func(Chirp) bool) {
+	cursors := chirpCursors{}
+
+	d.mu.Lock()
+	for _, authorId := range authorIds {
+		ids := d.chirpsByAuthor[authorId]
+		if len(ids) > 0 {
+			cursors = append(cursors, chirpCursor{authorId, ids[len(ids)-1]})
+		}
+	}
+	d.mu.Unlock()
+	heap.Init(&cursors)
+
+	for cursors.Len() > 0 {
+		cursor := heap.Pop(&cursors).(chirpCursor)
+
+		d.mu.Lock()
+		var chirp Chirp
+		i, ok := d.chirpIndex(cursor.chirpId)
+		if ok {
+			chirp = d.Chirps[i]
+		}
+
+		// The author's chirps may have changed since the cursor was made, so look the next one up by id
+		ids := d.chirpsByAuthor[cursor.authorId]
+		next, _ := slices.BinarySearch(ids, cursor.chirpId)
+		if next > 0 {
+			heap.Push(&cursors, chirpCursor{cursor.authorId, ids[next-1]})
+		}
+		d.mu.Unlock()
+
+		if ok && !yield(chirp) {
+			return
+		}
+	}
+}
+
+func (d *Database) getChirp(id int) (Chirp, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.chirpIndex(id)
+	if !ok {
+		return Chirp{}, false
+	}
+
+	return d.Chirps[i], true
+}
+
+func (d *Database) getUser(id int) (User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.userIndex(id)
+	if !ok {
+		return User{}, false
+	}
+
+	return d.Users[i], true
+}
+
+func (d *Database) listUsers() []User {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Users)
+}
+
+func (d *Database) listChirps() []Chirp {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Chirps)
+}
+
+func (d *Database) getUserByEmail(email string) (User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, user := range d.Users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func (d *Database) storeAuditEntry(e AuditEntry) (AuditEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.LatestAuditId++
+	e.Id = d.LatestAuditId
+	d.AuditLog = append(d.AuditLog, e)
+
+	return e, nil
+}
+
+func (d *Database) listAuditEntries() []AuditEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.AuditLog)
+}
+
+func (d *Database) storeExport(e DataExport) (DataExport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, export := range d.Exports {
+		if export.Id == e.Id {
+			d.Exports[i] = e
+			return e, nil
+		}
+	}
+	d.Exports = append(d.Exports, e)
+
+	return e, nil
+}
+
+// updateExport replaces a stored export, reporting false when it has been removed in the meantime
+func (d *Database) updateExport(e DataExport) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, export := range d.Exports {
+		if export.Id == e.Id {
+			d.Exports[i] = e
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Database) getExport(id string) (DataExport, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, export := range d.Exports {
+		if export.Id == id {
+			return export, true
+		}
+	}
+
+	return DataExport{}, false
+}
+
+func (d *Database) listExports() []DataExport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Exports)
+}
+
+func (d *Database) deleteExport(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Exports = slices.DeleteFunc(d.Exports, func(e DataExport) bool {
+		return e.Id == id
+	})
+}
+
+func (d *Database) storeAttachment(a Attachment) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Attachments = append(d.Attachments, a)
+}
+
+func (d *Database) getAttachment(id string) (Attachment, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, attachment := range d.Attachments {
+		if attachment.Id == id {
+			return attachment, true
+		}
+	}
+
+	return Attachment{}, false
+}
+
+// getAttachmentByKey finds the attachment a blob belongs to, either as the image or as its thumbnail
+func (d *Database) getAttachmentByKey(key string) (Attachment, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, attachment := range d.Attachments {
+		if attachment.Key == key || attachment.ThumbnailKey == key {
+			return attachment, true
+		}
+	}
+
+	return Attachment{}, false
+}
+
+func (d *Database) listAttachments() []Attachment {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Attachments)
+}
+
+func (d *Database) deleteAttachment(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Attachments = slices.DeleteFunc(d.Attachments, func(a Attachment) bool {
+		return a.Id == id
+	})
+}
+
+// claimAttachmentsLocked attaches the uploads a new chirp lists to it, returning the ids that could be claimed.
+// An upload that another chirp got to first is left out. d.mu must be held
+func (d *Database) claimAttachmentsLocked(c Chirp) []string {
+	claimed := []string{}
+	for _, id := range c.AttachmentIds {
+		for i, attachment := range d.Attachments {
+			if attachment.Id == id && attachment.UserId == c.AuthorId && attachment.ChirpId == nil {
+				chirpId := c.Id
+				d.Attachments[i].ChirpId = &chirpId
+				claimed = append(claimed, id)
+			}
+		}
+	}
+
+	return claimed
+}
+
+func (d *Database) deleteChirp(c Chirp) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deleteChirpLocked(c.Id)
+
+	return nil
+}
+
+// deleteChirpLocked removes a chirp while d.mu is held, ids of the remaining chirps are left untouched
+func (d *Database) deleteChirpLocked(id int) {
+	i, ok := d.chirpIndex(id)
+	if !ok {
+		return
+	}
+
+	deleted := d.Chirps[i]
+	d.unindexChirp(deleted)
+	d.Chirps = slices.Delete(d.Chirps, i, i+1)
+	// A deleted reply that was replied to stays in the reply index, so threads can still reach the replies below it
+	if deleted.InReplyTo != nil && len(d.replies[id]) > 0 {
+		if d.DeletedReplies == nil {
+			d.DeletedReplies = map[int]int{}
+		}
+		d.DeletedReplies[id] = *deleted.InReplyTo
+		addToIndex(d.replies, *deleted.InReplyTo, id)
+	}
+	d.emitChirpEvent(chirpEvent{Kind: chirpDeleted, Chirp: deleted})
+	d.ChirpVersions = slices.DeleteFunc(d.ChirpVersions, func(v ChirpVersion) bool {
+		return v.ChirpId == id
+	})
+	if d.likes[id] != nil {
+		d.Likes = slices.DeleteFunc(d.Likes, func(l Like) bool {
+			return l.ChirpId == id
+		})
+		delete(d.likes, id)
+	}
+	d.deleteNotificationsLocked(func(n Notification) bool {
+		return n.ChirpId != nil && *n.ChirpId == id
+	})
+
+	// A rechirp has nothing to show without the chirp it reposts, quotes keep their own text and stay
+	for _, rechirpId := range slices.Clone(d.rechirps[id]) {
+		d.deleteChirpLocked(rechirpId)
+	}
+	delete(d.rechirps, id)
+}
+
+// purgeUser permanently removes a user, their chirps are either deleted or kept without an author
+func (d *Database) purgeUser(id int, anonymizeChirps bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, ok := d.userIndex(id)
+	if !ok {
+		return errors.New("user does not exist")
+	}
+	delete(d.usernames, normalizeUsername(d.Users[i].Username))
+	d.unindexUserTerms(d.Users[i])
+	d.Users = slices.Delete(d.Users, i, i+1)
+
+	authored := []int{}
+	for i, chirp := range d.Chirps {
+		if chirp.AuthorId != id {
+			continue
+		}
+
+		// Rechirps without anyone behind them are meaningless, so they always go
+		if anonymizeChirps && chirp.RechirpOf == nil {
+			d.unindexChirp(chirp)
+			d.Chirps[i].AuthorId = 0
+			d.indexChirp(d.Chirps[i])
+			d.emitChirpEvent(chirpEvent{Kind: chirpUpdated, Chirp: d.Chirps[i], Previous: &chirp})
+		} else {
+			authored = append(authored, chirp.Id)
+		}
+	}
+
+	for _, chirpId := range authored {
+		d.deleteChirpLocked(chirpId)
+	}
+
+	for _, follow := range d.Follows {
+		if follow.FollowerId == id || follow.FolloweeId == id {
+			d.unindexFollow(follow)
+		}
+	}
+	d.Follows = slices.DeleteFunc(d.Follows, func(f Follow) bool {
+		return f.FollowerId == id || f.FolloweeId == id
+	})
+
+	for _, like := range d.Likes {
+		if like.UserId == id {
+			delete(d.likes[like.ChirpId], id)
+		}
+	}
+	d.Likes = slices.DeleteFunc(d.Likes, func(l Like) bool {
+		return l.UserId == id
+	})
+
+	d.deleteNotificationsLocked(func(n Notification) bool {
+		return n.UserId == id || n.ActorId == id
+	})
+
+	d.Reports = slices.DeleteFunc(d.Reports, func(r Report) bool {
+		return r.ReporterId == id
+	})
+
+	d.Blocks = slices.DeleteFunc(d.Blocks, func(b Block) bool {
+		return b.BlockerId == id || b.BlockedId == id
+	})
+	d.Mutes = slices.DeleteFunc(d.Mutes, func(m Mute) bool {
+		return m.MuterId == id || m.MutedId == id
+	})
+	delete(d.blocks, id)
+	delete(d.mutes, id)
+	for _, blocked := range d.blocks {
+		delete(blocked, id)
+	}
+	for _, muted := range d.mutes {
+		delete(muted, id)
+	}
+
+	return nil
+}
+
+const (
+	chirpCreated = "created"
+	chirpUpdated = "updated"
+	chirpDeleted = "deleted"
+)
+
+// chirpEvent describes a change to a stored chirp, Previous is only set for updates
+type chirpEvent struct {
+	Kind     string
+	Chirp    Chirp
+	Previous *Chirp
+}
+
+type Database struct {
+	Chirps        []Chirp        `json:"chirps"`
+	LatestChirpId int            `json:"latest_chirp_id"`
+	Users         []User         `json:"users"`
+	LatestUserId  int            `json:"latest_user_id"`
+	AuditLog      []AuditEntry   `json:"audit_log"`
+	LatestAuditId int            `json:"latest_audit_id"`
+	Exports       []DataExport   `json:"exports"`
+	Attachments   []Attachment   `json:"attachments"`
+	ChirpVersions []ChirpVersion `json:"chirp_versions"`
+	// Deleted replies that had replies of their own, by the chirp they replied to
+	DeletedReplies map[int]int `json:"deleted_replies"`
+	Follows        []Follow    `json:"follows"`
+	Likes          []Like      `json:"likes"`
+
+	Notifications        []Notification `json:"notifications"`
+	LatestNotificationId int            `json:"latest_notification_id"`
+
+	Blocks []Block `json:"blocks"`
+	Mutes  []Mute  `json:"mutes"`
+
+	Reports                    []Report             `json:"reports"`
+	LatestReportId             int                  `json:"latest_report_id"`
+	ModerationDecisions        []ModerationDecision `json:"moderation_decisions"`
+	LatestModerationDecisionId int                  `json:"latest_moderation_decision_id"`
+	mu                         sync.Mutex
+
+	// In-memory indexes, derived from the records above whenever the database is loaded
+	replies        map[int][]int
+	chirpsByAuthor map[int][]int
+	following      map[int]map[int]bool
+	followers      map[int]map[int]bool
+	likes          map[int]map[int]bool
+	rechirps       map[int][]int
+	quotes         map[int][]int
+	hashtags       map[string][]int
+	mentions       map[int][]int
+	usernames      map[string]int
+	notifications  map[int][]int
+	userTerms      []userTerm
+	blocks         map[int]map[int]bool
+	mutes          map[int]map[int]bool
+
+	chirpListeners []func(chirpEvent)
+
+	notificationListeners []func(Notification)
 }
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// streamBufferSize is how many events a subscriber may fall behind before it is dropped
+	streamBufferSize = 64
+	// streamHistorySize is how many past events are kept around for clients resuming with Last-Event-ID
+	streamHistorySize = 1024
+	streamHeartbeat   = 15 * time.Second
+)
+
+type streamEvent struct {
+	Id    int64
+	Kind  string
+	Chirp Chirp
+}
+
+// streamSubscriber receives events on a buffered channel, the channel is closed when the subscriber
+// falls too far behind so that a slow client never holds up the database
+type streamSubscriber struct {
+	events chan streamEvent
+}
+
+// chirpStream fans chirp events out to live subscribers and keeps a short history for resuming
+type chirpStream struct {
+	mu          sync.Mutex
+	lastId      int64
+	history     []streamEvent
+	subscribers map[*streamSubscriber]bool
+}
+
+func newChirpStream() *chirpStream {
+	return &chirpStream{subscribers: map[*streamSubscriber]bool{}}
+}
+
+// handleChirpEvent is registered through onChirpEvent, it never blocks on a subscriber
+func (s *chirpStream) handleChirpEvent(e chirpEvent) {
+	if e.Kind != chirpCreated && e.Kind != chirpDeleted {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	event := streamEvent{Id: s.lastId, Kind: e.Kind, Chirp: e.Chirp}
+	s.history = append(s.history, event)
+	if len(s.history) > streamHistorySize {
+		s.history = slices.Delete(s.history, 0, len(s.history)-streamHistorySize)
+	}
+
+	for sub := range s.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// subscribe registers a new subscriber, returning the events after lastEventId that are still in the history.
+// An id from before a restart is newer than anything seen since, nothing is replayed for it
+func (s *chirpStream) subscribe(lastEventId int64) (*streamSubscriber, []streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	missed := []streamEvent{}
+	if lastEventId > 0 && lastEventId <= s.lastId {
+		for _, event := range s.history {
+			if event.Id > lastEventId {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	sub := &streamSubscriber{events: make(chan streamEvent, streamBufferSize)}
+	s.subscribers[sub] = true
+
+	return sub, missed
+}
+
+func (s *chirpStream) unsubscribe(sub *streamSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscribers[sub] {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// streamFilter narrows the stream down to the chirps a subscriber asked for, every set field has to match
+type streamFilter struct {
+	authorId *int
+	hashtag  string
+	timeline bool
+}
+
+func parseStreamFilter(r *http.Request) (streamFilter, error) {
+	filter := streamFilter{}
+	query := r.URL.Query()
+
+	if queryAuthor := query.Get("author_id"); queryAuthor != "" {
+		authorId, err := strconv.Atoi(queryAuthor)
+		if err != nil {
+			return streamFilter{}, errors.New("author_id is not a valid number")
+		}
+		filter.authorId = &authorId
+	}
+
+	if queryHashtag := query.Get("hashtag"); queryHashtag != "" {
+		filter.hashtag = normalizeHashtag(queryHashtag)
+	}
+
+	if queryTimeline := query.Get("timeline"); queryTimeline != "" {
+		timeline, err := strconv.ParseBool(queryTimeline)
+		if err != nil {
+			return streamFilter{}, errors.New("timeline must be true or false")
+		}
+		filter.timeline = timeline
+	}
+
+	return filter, nil
+}
+
+func (cfg *apiConfig) streamMatches(filter streamFilter, viewer *User, chirp Chirp) bool {
+	// Like GET /api/chirps, the unfiltered and hashtag streams leave out unlisted chirps
+	if !chirp.listed() && filter.authorId == nil && !filter.timeline {
+		return false
+	}
+
+	if filter.authorId != nil && chirp.AuthorId != *filter.authorId {
+		return false
+	}
+
+	if filter.hashtag != "" && !slices.Contains(chirpHashtags(chirp.Body), filter.hashtag) {
+		return false
+	}
+
+	if filter.timeline && chirp.AuthorId != viewer.Id && !cfg.database.isFollowing(viewer.Id, chirp.AuthorId) {
+		return false
+	}
+
+	return true
+}
+
+// handlerStreamChirps pushes created and deleted chirps as Server-Sent Events. A client that falls too far
+// behind is disconnected, reconnecting with Last-Event-ID picks up where it left off
+func (cfg *apiConfig) handlerStreamChirps(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, 500, "Streaming is not supported")
+		return
+	}
+
+	filter, err := parseStreamFilter(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if filter.timeline {
+		if _, err := cfg.authenticate(r); err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+	}
+	viewer := cfg.optionalViewer(r)
+
+	var lastEventId int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		lastEventId, err = strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			respondWithError(w, 400, "Last-Event-ID is not a valid number")
+			return
+		}
+	}
+
+	sub, missed := cfg.stream.subscribe(lastEventId)
+	defer cfg.stream.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	send := func(event streamEvent) error {
+		if !cfg.streamMatches(filter, viewer, event.Chirp) {
+			return nil
+		}
+
+		var payload interface{}
+		if event.Kind == chirpDeleted {
+			// The chirp is gone, so whether it may be seen is judged on the snapshot taken when it was deleted
+			if !cfg.inFeed(viewer, event.Chirp) {
+				return nil
+			}
+			payload = struct {
+				Id int `json:"id"`
+			}{event.Chirp.Id}
+		} else {
+			chirp, ok := cfg.database.getChirp(event.Chirp.Id)
+			if !ok || !cfg.inFeed(viewer, chirp) {
+				return nil
+			}
+			payload = cfg.newChirpResponse(viewer, chirp)
+		}
+
+		dat, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Kind, dat); err != nil {
+			return err
+		}
+		flusher.Flush()
+
+		return nil
+	}
+
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
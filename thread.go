@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// threadChirp is a chirp within a conversation, a chirp that was deleted or can not be seen only keeps its id
+// so the shape of the conversation is preserved
+type threadChirp struct {
+	Id int `json:"id"`
+	*chirpResponse
+	Unavailable bool          `json:"unavailable,omitempty"`
+	Replies     []threadChirp `json:"replies,omitempty"`
+}
+
+// replyTree collects the replies to a chirp and all of their replies in turn. A reply that was deleted or can
+// not be seen is kept as an unavailable placeholder when there are replies below it the viewer can see
+func (cfg *apiConfig) replyTree(viewer *User, chirpId int) []threadChirp {
+	tree := []threadChirp{}
+	for _, replyId := range cfg.database.getReplyIds(chirpId) {
+		reply, ok := cfg.database.getChirp(replyId)
+		if !ok || !cfg.canView(viewer, reply) {
+			if replies := cfg.replyTree(viewer, replyId); len(replies) > 0 {
+				tree = append(tree, threadChirp{Id: replyId, Unavailable: true, Replies: replies})
+			}
+			continue
+		}
+
+		view := cfg.newChirpResponse(viewer, reply)
+		tree = append(tree, threadChirp{
+			chirpResponse: &view,
+			Id:            reply.Id,
+			Replies:       cfg.replyTree(viewer, reply.Id),
+		})
+	}
+
+	return tree
+}
+
+// countVisibleReplies counts the direct replies to a chirp that viewer can see
+func (cfg *apiConfig) countVisibleReplies(viewer *User, chirpId int) int {
+	count := 0
+	for _, reply := range cfg.database.getReplies(chirpId) {
+		if cfg.canView(viewer, reply) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (cfg *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 400, "ID param is not a valid number")
+		return
+	}
+
+	viewer := cfg.optionalViewer(r)
+	chirp, ok := cfg.database.getChirp(chirpID)
+	if !ok || !cfg.canView(viewer, chirp) {
+		respondWithError(w, 404, "Chirp does not exist")
+		return
+	}
+
+	// Walk up to the root of the conversation, like in replyTree a parent that was deleted or can not be seen
+	// becomes a placeholder and the walk goes on above it
+	ancestors := []threadChirp{}
+	parentId, ok := cfg.database.getParentId(chirp.Id)
+	for ok {
+		parent, found := cfg.database.getChirp(parentId)
+		if found && cfg.canView(viewer, parent) {
+			view := cfg.newChirpResponse(viewer, parent)
+			ancestors = append(ancestors, threadChirp{chirpResponse: &view, Id: parent.Id})
+		} else {
+			ancestors = append(ancestors, threadChirp{Id: parentId, Unavailable: true})
+		}
+		parentId, ok = cfg.database.getParentId(parentId)
+	}
+
+	// Oldest first, so the conversation reads top to bottom
+	slices.Reverse(ancestors)
+
+	type threadResponse struct {
+		Ancestors []threadChirp `json:"ancestors"`
+		Chirp     chirpResponse `json:"chirp"`
+		Replies   []threadChirp `json:"replies"`
+	}
+
+	respondWithJSON(w, 200, threadResponse{
+		Ancestors: ancestors,
+		Chirp:     cfg.newChirpResponse(viewer, chirp),
+		Replies:   cfg.replyTree(viewer, chirp.Id),
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import "net/http"
+
+// chirpCursor points at the newest chirp of an author that has not been visited yet
+type chirpCursor struct {
+	authorId int
+	chirpId  int
+}
+
+// chirpCursors is a max-heap on chirp id, merging the chirps of many authors newest first
+// without having to look at more chirps than are asked for
+type chirpCursors []chirpCursor
+
+func (c chirpCursors) Len() int           { return len(c) }
+func (c chirpCursors) Less(i, j int) bool { return c[i].chirpId > c[j].chirpId }
+func (c chirpCursors) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+func (c *chirpCursors) Push(x any) {
+	*c = append(*c, x.(chirpCursor))
+}
+
+func (c *chirpCursors) Pop() any {
+	old := *c
+	n := len(old)
+	x := old[n-1]
+	*c = old[:n-1]
+	return x
+}
+
+// handlerGetTimeline is the home timeline, the chirps of every account the user follows and their own,
+// merged on read so following thousands of accounts only costs as much as the page being read
+func (cfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.authenticate(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	authors := append(cfg.database.listFollowing(user.Id), user.Id)
+
+	chirps := []chirpResponse{}
+	skipped := 0
+	cfg.database.walkAuthorsChirps(authors, func(chirp Chirp) bool {
+		if !cfg.inFeed(&user, chirp) {
+			return true
+		}
+		if skipped < offset {
+			skipped++
+			return true
+		}
+
+		chirps = append(chirps, cfg.newChirpResponse(&user, chirp))
+		return len(chirps) < limit
+	})
+
+	respondWithJSON(w, 200, chirps)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	trendBucketSize    = 5 * time.Minute
+	maxTrendWindow     = 7 * 24 * time.Hour
+	defaultTrendsLimit = 10
+	minTermLength      = 4
+)
+
+var trendWindows = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": maxTrendWindow,
+}
+
+// trendStopWords are common words that would otherwise trend all the time
+var trendStopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "also": true, "been": true, "before": true, "being": true,
+	"could": true, "does": true, "doing": true, "from": true, "have": true, "having": true, "here": true,
+	"into": true, "just": true, "like": true, "more": true, "most": true, "much": true, "only": true,
+	"other": true, "over": true, "really": true, "same": true, "should": true, "some": true, "such": true,
+	"than": true, "that": true, "their": true, "them": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "those": true, "very": true, "want": true, "were": true, "what": true,
+	"when": true, "where": true, "which": true, "while": true, "will": true, "with": true, "would": true,
+	"your": true, "yours": true,
+}
+
+// trackedChirp remembers what a chirp contributed to the trends, so it can be taken back out again
+type trackedChirp struct {
+	bucket int64
+	terms  []string
+}
+
+// trendTracker keeps per-term counts in fixed time buckets, updated as chirps come and go,
+// so computing the trends only looks at the terms seen within the longest window
+type trendTracker struct {
+	mu         sync.Mutex
+	counts     map[string]map[int64]int
+	chirps     map[int]trackedChirp
+	lastPruned int64
+}
+
+func newTrendTracker() *trendTracker {
+	return &trendTracker{
+		counts: map[string]map[int64]int{},
+		chirps: map[int]trackedChirp{},
+	}
+}
+
+func trendBucket(t time.Time) int64 {
+	return t.UnixNano() / int64(trendBucketSize)
+}
+
+// trendTerms extracts what a chirp can trend with, hashtags keep their # to set them apart from plain words
+func trendTerms(body string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, tag := range chirpHashtags(body) {
+		add("#" + tag)
+	}
+
+	for _, word := range strings.Fields(body) {
+		if strings.ContainsAny(word, "#@/") || strings.Contains(word, "****") {
+			continue
+		}
+
+		word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		}))
+		if len([]rune(word)) < minTermLength || trendStopWords[word] {
+			continue
+		}
+		if strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) }) != -1 {
+			continue
+		}
+
+		add(word)
+	}
+
+	return terms
+}
+
+// add counts a chirp's terms, hidden chirps stay out until a moderator lets them back in
+func (t *trendTracker) add(chirp Chirp) {
+	if time.Since(chirp.CreatedAt) > maxTrendWindow || !chirp.listed() || chirp.Hidden {
+		return
+	}
+
+	tracked := trackedChirp{bucket: trendBucket(chirp.CreatedAt), terms: trendTerms(chirp.Body)}
+	t.chirps[chirp.Id] = tracked
+	for _, term := range tracked.terms {
+		if t.counts[term] == nil {
+			t.counts[term] = map[int64]int{}
+		}
+		t.counts[term][tracked.bucket]++
+	}
+}
+
+func (t *trendTracker) remove(chirpId int) {
+	tracked, ok := t.chirps[chirpId]
+	if !ok {
+		return
+	}
+
+	delete(t.chirps, chirpId)
+	for _, term := range tracked.terms {
+		t.counts[term][tracked.bucket]--
+		if t.counts[term][tracked.bucket] <= 0 {
+			delete(t.counts[term], tracked.bucket)
+		}
+		if len(t.counts[term]) == 0 {
+			delete(t.counts, term)
+		}
+	}
+}
+
+// prune forgets everything older than the longest window, at most once per bucket
+func (t *trendTracker) prune(now time.Time) {
+	current := trendBucket(now)
+	if current == t.lastPruned {
+		return
+	}
+	t.lastPruned = current
+
+	oldest := trendBucket(now.Add(-maxTrendWindow))
+	for id, tracked := range t.chirps {
+		if tracked.bucket < oldest {
+			t.remove(id)
+		}
+	}
+}
+
+// handleChirpEvent keeps the tracker in step with the database, it is registered through onChirpEvent
+func (t *trendTracker) handleChirpEvent(e chirpEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch e.Kind {
+	case chirpCreated:
+		t.add(e.Chirp)
+	case chirpUpdated:
+		t.remove(e.Chirp.Id)
+		t.add(e.Chirp)
+	case chirpDeleted:
+		t.remove(e.Chirp.Id)
+	}
+	t.prune(time.Now())
+}
+
+type trend struct {
+	Term  string  `json:"term"`
+	Type  string  `json:"type"`
+	Count int     `json:"count"`
+	Score float64 `json:"score"`
+}
+
+// trending scores every term seen within window, each bucket counts half as much every quarter window
+// so a spike fades out well before it leaves the window entirely
+func (t *trendTracker) trending(window time.Duration, now time.Time) []trend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := trendBucket(now)
+	oldest := trendBucket(now.Add(-window))
+	halfLife := float64(window) / 4
+
+	trends := []trend{}
+	for term, buckets := range t.counts {
+		count := 0
+		score := 0.0
+		for bucket, n := range buckets {
+			if bucket <= oldest || bucket > current {
+				continue
+			}
+
+			age := float64(current-bucket) * float64(trendBucketSize)
+			count += n
+			score += float64(n) * math.Pow(0.5, age/halfLife)
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		kind := "term"
+		if strings.HasPrefix(term, "#") {
+			kind = "hashtag"
+		}
+		trends = append(trends, trend{Term: term, Type: kind, Count: count, Score: score})
+	}
+
+	slices.SortFunc(trends, func(a, b trend) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Term, b.Term)
+	})
+
+	return trends
+}
+
+func (cfg *apiConfig) handlerGetTrends(w http.ResponseWriter, r *http.Request) {
+	window := trendWindows["day"]
+	if queryWindow := r.URL.Query().Get("window"); queryWindow != "" {
+		named, ok := trendWindows[queryWindow]
+		if !ok {
+			d, err := time.ParseDuration(queryWindow)
+			if err != nil || d < trendBucketSize || d > maxTrendWindow {
+				respondWithError(w, 400, "window must be hour, day, week or a duration between 5m and 168h")
+				return
+			}
+			named = d
+		}
+		window = named
+	}
+
+	kind := r.URL.Query().Get("type")
+	if kind != "" && kind != "hashtag" && kind != "term" {
+		respondWithError(w, 400, "type must be hashtag or term")
+		return
+	}
+
+	limit := defaultTrendsLimit
+	if queryLimit := r.URL.Query().Get("limit"); queryLimit != "" {
+		l, err := strconv.Atoi(queryLimit)
+		if err != nil || l < 1 {
+			respondWithError(w, 400, "limit must be a positive number")
+			return
+		}
+		limit = min(l, maxPageSize)
+	}
+
+	trends := []trend{}
+	for _, t := range cfg.trends.trending(window, time.Now()) {
+		if kind != "" && t.Type != kind {
+			continue
+		}
+
+		trends = append(trends, t)
+		if len(trends) == limit {
+			break
+		}
+	}
+
+	type trendsResponse struct {
+		Window string  `json:"window"`
+		Trends []trend `json:"trends"`
+	}
+
+	respondWithJSON(w, 200, trendsResponse{window.String(), trends})
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -17,8 +18,10 @@ import (
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -58,53 +61,31 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user := User{Email: params.Email, Password: string(passHash)}
-	user, err = cfg.database.storeUser(user)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to store user in database")
-		w.WriteHeader(500)
+	if params.Username != "" && !validUsername(params.Username) {
+		respondWithError(w, 400, errInvalidUsername.Error())
 		return
 	}
 
-	dat, err := json.Marshal(user)
+	displayName, err := cleanDisplayName(params.DisplayName)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-		w.WriteHeader(500)
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	w.Write(dat)
-}
-
-func (cfg *apiConfig) handlerGetUsers(w http.ResponseWriter, r *http.Request) {
-	userMap := make([]User, len(cfg.database.Users))
-	for i, user := range cfg.database.Users {
-		userMap[i] = user
+	// Bootstrap: registering with ADMIN_EMAIL makes the first admin, once there is one the address is nothing special
+	user := User{Email: params.Email, Password: string(passHash), Role: roleUser, Username: params.Username, DisplayName: displayName}
+	user, err = cfg.database.storeNewUser(user, cfg.adminEmail)
+	if errors.Is(err, errUsernameTaken) {
+		respondWithError(w, 409, err.Error())
+		return
 	}
-
-	data, err := json.Marshal(&userMap)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error decoding parameters: %s\n", err)
-
-		resp := errorResponse{"Something went wrong"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(os.Stderr, "Failed to store user in database")
 		w.WriteHeader(500)
-		w.Write(dat)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(data)
+	respondWithJSON(w, 201, cfg.newAccountResponse(user))
 }
 
 func (cfg *apiConfig) handlerGetUser(w http.ResponseWriter, r *http.Request) {
@@ -126,14 +107,15 @@ func (cfg *apiConfig) handlerGetUser(w http.ResponseWriter, r *http.Request) {
 
 	var user *User
 
-	for _, userInDb := range cfg.database.Users {
-		if userInDb.Id == lookingFor {
-			user = &userInDb
-		}
+	userInDb, ok := cfg.database.getUser(lookingFor)
+	viewer := cfg.optionalViewer(r)
+	blocked := ok && viewer != nil && cfg.database.hasBlocked(userInDb.Id, viewer.Id)
+	if ok && userInDb.DeletedAt == nil && !blocked {
+		user = &userInDb
 	}
 
 	if user == nil {
-		resp := errorResponse{"Chirp does not exist"}
+		resp := errorResponse{"User does not exist"}
 		dat, err := json.Marshal(resp)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
@@ -147,7 +129,7 @@ func (cfg *apiConfig) handlerGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := json.Marshal(user)
+	data, err := json.Marshal(cfg.newUserProfile(*user))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -182,7 +164,7 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	var user *User
 
 	for _, userInDb := range cfg.database.Users {
-		if userInDb.Email == params.Email {
+		if userInDb.Email == params.Email && userInDb.DeletedAt == nil {
 			user = &userInDb
 		}
 	}
@@ -208,6 +190,11 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.Suspended {
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+
 	jwToken := jwt.NewWithClaims(
 		jwt.SigningMethodHS256,
 		jwt.RegisteredClaims{
@@ -271,17 +258,36 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.RefreshTokenSecret = &encRefresh
-	cfg.database.storeUser(*user)
+	// Only the refresh secret is written, the rest of user may have changed while the password was checked
+	*user, err = cfg.database.updateUser(user.Id, func(u *User) error {
+		if u.DeletedAt != nil {
+			return errUnknownUser
+		}
+		if u.Suspended {
+			return errSuspended
+		}
+		u.RefreshTokenSecret = &encRefresh
+		return nil
+	})
+	if errors.Is(err, errUnknownUser) {
+		respondWithError(w, 404, "User does not exist")
+		return
+	}
+	if errors.Is(err, errSuspended) {
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
 
 	type userResponse struct {
-		Id           int    `json:"id"`
-		Email        string `json:"email"`
-		Red          bool   `json:"is_chirpy_red"`
+		accountResponse
 		Token        string `json:"token"`
 		RefreshToken string `json:"refresh_token"`
 	}
-	userResp := userResponse{user.Id, user.Email, user.Red, signedToken, refreshSignedToken}
+	userResp := userResponse{cfg.newAccountResponse(*user), signedToken, refreshSignedToken}
 	data, err := json.Marshal(&userResp)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -290,82 +296,17 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
-	bearer := r.Header.Get("Authorization")
-	if bearer == "" || !strings.Contains(bearer, "Bearer ") {
-		resp := errorResponse{"Authorization token is missing"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(401)
-		w.Write(dat)
-		return
-	}
-
-	bearer = strings.Split(bearer, " ")[1]
-	token, err := jwt.ParseWithClaims(
-		bearer,
-		&jwt.RegisteredClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(cfg.jwtSecret), nil
-		},
-	)
+	user, err := cfg.authenticate(r)
 	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-
-	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		resp := errorResponse{"Failed to get ID from jwt"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(500)
-		w.Write(dat)
-		return
-	}
-
-	userID, err := strconv.Atoi(userId)
-	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-
-	var user *User
-	for _, userInDb := range cfg.database.Users {
-		if userInDb.Id == userID {
-			user = &userInDb
-		}
-	}
-
-	if user == nil {
-		resp := errorResponse{"User does not exist"}
-		dat, err := json.Marshal(resp)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
-			w.WriteHeader(500)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(404)
-		w.Write(dat)
+		respondWithAuthError(w, err)
 		return
 	}
 
 	type parameters struct {
-		Password *string `json:"password"`
-		Email    *string `json:"email"`
+		Password    *string `json:"password"`
+		Email       *string `json:"email"`
+		Username    *string `json:"username"`
+		DisplayName *string `json:"display_name"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -387,8 +328,8 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if params.Email == nil && params.Password == nil {
-		resp := errorResponse{"Neither email nor password given, cannot update"}
+	if params.Email == nil && params.Password == nil && params.Username == nil && params.DisplayName == nil {
+		resp := errorResponse{"Neither email, password, username nor display name given, cannot update"}
 		dat, err := json.Marshal(resp)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Failed marshalling json error response")
@@ -402,6 +343,7 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	var passwordHash string
 	if params.Password != nil {
 		passHash, err := bcrypt.GenerateFromPassword([]byte(*params.Password), 10)
 		if err != nil {
@@ -421,21 +363,52 @@ func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		user.Password = string(passHash)
+		passwordHash = string(passHash)
 	}
 
-	if params.Email != nil {
-		user.Email = *params.Email
+	if params.Username != nil {
+		if !validUsername(*params.Username) {
+			respondWithError(w, 400, errInvalidUsername.Error())
+			return
+		}
 	}
 
-	cfg.database.storeUser(*user)
+	var displayName string
+	if params.DisplayName != nil {
+		displayName, err = cleanDisplayName(*params.DisplayName)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
 
-	type userResponse struct {
-		Id    int    `json:"id"`
-		Email string `json:"email"`
+	// only the fields given are written, under the lock, so a profile image uploaded or a session revoked
+	// while the password was being hashed is not reverted
+	user, err = cfg.database.updateUser(user.Id, func(u *User) error {
+		if params.Password != nil {
+			u.Password = passwordHash
+		}
+		if params.Email != nil {
+			u.Email = *params.Email
+		}
+		if params.Username != nil {
+			u.Username = *params.Username
+		}
+		if params.DisplayName != nil {
+			u.DisplayName = displayName
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errUsernameTaken) {
+			respondWithError(w, 409, err.Error())
+			return
+		}
+		respondWithError(w, 500, "Failed to store user in database")
+		return
 	}
-	userResp := userResponse{user.Id, user.Email}
-	data, err := json.Marshal(&userResp)
+
+	data, err := json.Marshal(cfg.newAccountResponse(user))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
@@ -505,6 +478,11 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if user.Suspended {
+		respondWithError(w, 403, "Account is suspended")
+		return
+	}
+
 	jwToken := jwt.NewWithClaims(
 		jwt.SigningMethodHS256,
 		jwt.RegisteredClaims{
@@ -606,16 +584,77 @@ func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user.RefreshTokenSecret = nil
-	cfg.database.storeUser(*user)
+	// The access tokens go too, otherwise a kicked socket could reconnect right away
+	_, err = cfg.database.updateUser(user.Id, func(u *User) error {
+		revokeSessions(u)
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, 500, "Failed to store user in database")
+		return
+	}
+	cfg.sockets.disconnectUser(user.Id, "Session was revoked")
 
 	w.WriteHeader(204)
 }
 
+// userProfile is the public view of a user, anything private to the account stays out of it
+type userProfile struct {
+	Id             int     `json:"id"`
+	Username       string  `json:"username"`
+	DisplayName    string  `json:"display_name"`
+	Red            bool    `json:"is_chirpy_red"`
+	FollowerCount  int     `json:"follower_count"`
+	FollowingCount int     `json:"following_count"`
+	AvatarUrl      *string `json:"avatar_url"`
+	BannerUrl      *string `json:"banner_url"`
+}
+
+func (cfg *apiConfig) newUserProfile(user User) userProfile {
+	followers, following := cfg.database.countFollows(user.Id)
+
+	return userProfile{
+		Id:             user.Id,
+		Username:       user.Username,
+		DisplayName:    user.DisplayName,
+		Red:            user.Red,
+		FollowerCount:  followers,
+		FollowingCount: following,
+		AvatarUrl:      cfg.profileImageUrl(user.AvatarKey),
+		BannerUrl:      cfg.profileImageUrl(user.BannerKey),
+	}
+}
+
+// accountResponse is how users see their own account, the public profile plus the email and role.
+// Secrets like the password hash never leave the server
+type accountResponse struct {
+	userProfile
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (cfg *apiConfig) newAccountResponse(user User) accountResponse {
+	return accountResponse{cfg.newUserProfile(user), user.Email, user.Role}
+}
+
 type User struct {
 	Id                 int     `json:"id"`
 	Email              string  `json:"email"`
+	Username           string  `json:"username"`
+	DisplayName        string  `json:"display_name"`
 	Password           string  `json:"password"`
 	RefreshTokenSecret *string `json:"refresh_token_secret"`
 	Red                bool    `json:"is_chirpy_red"`
+	Role               string  `json:"role"`
+	Suspended          bool    `json:"is_suspended"`
+	// Access tokens issued before this point in time are no longer accepted
+	TokensRevokedAt *time.Time `json:"tokens_revoked_at"`
+	// Set once deletion is requested, the account is purged for good after the grace period
+	DeletedAt       *time.Time `json:"deleted_at"`
+	AnonymizeChirps bool       `json:"anonymize_chirps"`
+	// Notification types a user switched on or off, types missing from it are on
+	NotificationPreferences map[string]bool `json:"notification_preferences"`
+	// Blob keys of the profile images, empty when the user has none
+	AvatarKey string `json:"avatar_key"`
+	BannerKey string `json:"banner_key"`
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 50
+	// maxUserCandidates caps how many users a typeahead query ranks, a short prefix matches nearly everyone
+	maxUserCandidates = 200
+)
+
+var errInvalidDisplayName = errors.New("Display name must be at most 50 characters without control characters")
+
+// cleanDisplayName trims a display name and checks it, an empty display name clears it
+func cleanDisplayName(displayName string) (string, error) {
+	displayName = strings.TrimSpace(displayName)
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength || strings.IndexFunc(displayName, unicode.IsControl) != -1 {
+		return "", errInvalidDisplayName
+	}
+
+	return displayName, nil
+}
+
+// Fields a user can be found by, in order of how good a match on them is
+const (
+	userFieldUsername = iota
+	userFieldDisplayName
+	userFieldEmail
+)
+
+// userTerm is an entry of the sorted prefix index over users
+type userTerm struct {
+	key    string
+	userId int
+	field  int
+}
+
+func compareUserTerms(a, b userTerm) int {
+	if c := strings.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	if a.userId != b.userId {
+		return a.userId - b.userId
+	}
+	return a.field - b.field
+}
+
+// userTermsOf lists the keys a user is indexed under, every word of the display name is a key of its own
+// so that "smith" finds "Jane Smith"
+func userTermsOf(u User) []userTerm {
+	terms := []userTerm{}
+	if u.Username != "" {
+		terms = append(terms, userTerm{normalizeUsername(u.Username), u.Id, userFieldUsername})
+	}
+
+	displayName := strings.ToLower(u.DisplayName)
+	if displayName != "" {
+		terms = append(terms, userTerm{displayName, u.Id, userFieldDisplayName})
+		words := strings.Fields(displayName)
+		for i := 1; i < len(words); i++ {
+			terms = append(terms, userTerm{words[i], u.Id, userFieldDisplayName})
+		}
+	}
+
+	if u.Email != "" {
+		terms = append(terms, userTerm{strings.ToLower(u.Email), u.Id, userFieldEmail})
+	}
+
+	return terms
+}
+
+// indexUserTerms adds a user to the prefix index, d.mu must be held
+func (d *Database) indexUserTerms(u User) {
+	for _, term := range userTermsOf(u) {
+		i, found := slices.BinarySearchFunc(d.userTerms, term, compareUserTerms)
+		if !found {
+			d.userTerms = slices.Insert(d.userTerms, i, term)
+		}
+	}
+}
+
+// unindexUserTerms removes a user from the prefix index, d.mu must be held
+func (d *Database) unindexUserTerms(u User) {
+	for _, term := range userTermsOf(u) {
+		if i, found := slices.BinarySearchFunc(d.userTerms, term, compareUserTerms); found {
+			d.userTerms = slices.Delete(d.userTerms, i, i+1)
+		}
+	}
+}
+
+type userMatch struct {
+	userId int
+	field  int
+	exact  bool
+}
+
+// searchUserPrefix finds up to limit users with a key starting with prefix, keeping the best matching field
+// per user. Keys are walked in order, so an exact match always makes it in. Users viewerId may not find are
+// left out before they count towards limit, admins also find suspended users and search the emails
+func (d *Database) searchUserPrefix(prefix string, viewerId int, isAdmin bool, limit int) []userMatch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	best := map[int]userMatch{}
+	start, _ := slices.BinarySearchFunc(d.userTerms, userTerm{key: prefix}, compareUserTerms)
+	for _, term := range d.userTerms[start:] {
+		if !strings.HasPrefix(term.key, prefix) {
+			break
+		}
+		if term.field == userFieldEmail && !isAdmin {
+			continue
+		}
+
+		match := userMatch{term.userId, term.field, term.key == prefix}
+		current, ok := best[term.userId]
+		if !ok && (len(best) >= limit || !d.findableLocked(term.userId, viewerId, isAdmin)) {
+			continue
+		}
+		if !ok || match.field < current.field || (match.field == current.field && match.exact && !current.exact) {
+			best[term.userId] = match
+		}
+	}
+
+	matches := make([]userMatch, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// findableLocked checks whether a user shows up in the searches of viewerId, d.mu must be held
+func (d *Database) findableLocked(userId, viewerId int, isAdmin bool) bool {
+	i, ok := d.userIndex(userId)
+	if !ok || d.Users[i].DeletedAt != nil || (d.Users[i].Suspended && !isAdmin) {
+		return false
+	}
+
+	return !d.blocks[userId][viewerId]
+}
+
+// handlerSearchUsers serves typeahead lookups: exact usernames first, then the people the viewer follows,
+// then username, display name and email prefixes, with the more followed accounts first after that
+func (cfg *apiConfig) handlerSearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	q = strings.TrimPrefix(q, "@")
+	if q == "" {
+		respondWithError(w, 400, "q must not be empty")
+		return
+	}
+
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		respondWithError(w, 400, "limit and offset must be positive numbers")
+		return
+	}
+
+	viewer := cfg.optionalViewer(r)
+	isAdmin := viewer != nil && roleRank(viewer.Role) >= roleRank(roleAdmin)
+
+	type rankedUser struct {
+		user      User
+		match     userMatch
+		followed  bool
+		followers int
+	}
+
+	viewerId := 0
+	if viewer != nil {
+		viewerId = viewer.Id
+	}
+
+	// Enough candidates to fill the requested page, even past maxUserCandidates
+	ranked := []rankedUser{}
+	for _, match := range cfg.database.searchUserPrefix(q, viewerId, isAdmin, max(maxUserCandidates, offset+limit)) {
+		user, ok := cfg.database.getUser(match.userId)
+		if !ok {
+			continue
+		}
+
+		followed := viewer != nil && cfg.database.isFollowing(viewer.Id, user.Id)
+		followers, _ := cfg.database.countFollows(user.Id)
+		ranked = append(ranked, rankedUser{user, match, followed, followers})
+	}
+
+	slices.SortFunc(ranked, func(a, b rankedUser) int {
+		aExact := a.match.exact && a.match.field == userFieldUsername
+		bExact := b.match.exact && b.match.field == userFieldUsername
+		switch {
+		case aExact != bExact:
+			if aExact {
+				return -1
+			}
+			return 1
+		case a.followed != b.followed:
+			if a.followed {
+				return -1
+			}
+			return 1
+		case a.match.field != b.match.field:
+			return a.match.field - b.match.field
+		case a.followers != b.followers:
+			return b.followers - a.followers
+		}
+		return a.user.Id - b.user.Id
+	})
+
+	type userResult struct {
+		userProfile
+		Email string `json:"email,omitempty"`
+	}
+
+	results := []userResult{}
+	for _, entry := range ranked[min(offset, len(ranked)):] {
+		result := userResult{userProfile: cfg.newUserProfile(entry.user)}
+		if isAdmin {
+			result.Email = entry.user.Email
+		}
+
+		results = append(results, result)
+		if len(results) == limit {
+			break
+		}
+	}
+
+	respondWithJSON(w, 200, results)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	visibilityPublic    = "public"
+	visibilityUnlisted  = "unlisted"
+	visibilityFollowers = "followers"
+	visibilityMentioned = "mentioned"
+)
+
+var visibilities = []string{visibilityPublic, visibilityUnlisted, visibilityFollowers, visibilityMentioned}
+
+// parseVisibility validates the visibility a chirp is written with, leaving it out makes the chirp public
+func parseVisibility(visibility string) (string, error) {
+	if visibility == "" {
+		return visibilityPublic, nil
+	}
+
+	if !slices.Contains(visibilities, visibility) {
+		return "", fmt.Errorf("visibility must be one of %s", strings.Join(visibilities, ", "))
+	}
+
+	return visibility, nil
+}
+
+// listed reports whether a chirp shows up in the public listings, search, trends and the unfiltered stream.
+// Other chirps can still be opened and are shown on the author's profile and in timelines to those who may see them
+func (c Chirp) listed() bool {
+	return c.Visibility == visibilityPublic
+}
+
+// visibleTo checks a chirp's visibility against viewer, followers-only chirps are also shown to the users
+// they mention. The author always sees their own chirps
+func (cfg *apiConfig) visibleTo(viewer *User, chirp Chirp) bool {
+	switch chirp.Visibility {
+	case visibilityFollowers:
+		if viewer != nil && cfg.database.isFollowing(viewer.Id, chirp.AuthorId) {
+			return true
+		}
+		fallthrough
+	case visibilityMentioned:
+		return viewer != nil && (viewer.Id == chirp.AuthorId || slices.Contains(chirp.MentionIds, viewer.Id))
+	default:
+		return true
+	}
+}
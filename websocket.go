@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 server side, enough for JSON text messages and the control frames around them
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsClosePolicy        = 1008
+	wsCloseTooBig        = 1009
+	wsCloseTryAgainLater = 1013
+
+	wsMaxMessageSize = 64 * 1024
+	wsWriteTimeout   = 10 * time.Second
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	errWsClosed   = errors.New("websocket closed")
+	errWsProtocol = errors.New("websocket protocol error")
+	errWsTooBig   = errors.New("websocket message too big")
+	errWsBinary   = errors.New("websocket binary messages are not supported")
+)
+
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake, on failure a response has already been written
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, bool) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") || key == "" {
+		respondWithError(w, 400, "Expected a WebSocket upgrade request")
+		return nil, false
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		respondWithError(w, 426, "Unsupported WebSocket version")
+		return nil, false
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		respondWithError(w, 500, "WebSockets are not supported")
+		return nil, false
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		respondWithError(w, 500, "WebSockets are not supported")
+		return nil, false
+	}
+
+	accept := sha1.Sum([]byte(key + wsGUID))
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"))
+	if err != nil {
+		conn.Close()
+		return nil, false
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader}, true
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closed {
+		return errWsClosed
+	}
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	if opcode == wsOpClose {
+		c.closed = true
+	}
+
+	return nil
+}
+
+func (c *wsConn) writeText(payload []byte) error {
+	return c.writeFrame(wsOpText, payload)
+}
+
+func (c *wsConn) ping() error {
+	return c.writeFrame(wsOpPing, nil)
+}
+
+// close sends a close frame with a status code and reason, then drops the connection
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsOpClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame reads a single frame, client frames must always be masked
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		return false, 0, nil, errWsProtocol
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	isControl := opcode&0x8 != 0
+	if isControl && (length > 125 || !fin) {
+		return false, 0, nil, errWsProtocol
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, errWsTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next complete text message, answering pings and reassembling fragments on the way.
+// Every frame read, pongs included, pushes the read deadline back by idle
+func (c *wsConn) readMessage(idle time.Duration) ([]byte, error) {
+	var message []byte
+	fragmented := false
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idle))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.close(code, "")
+			return nil, errWsClosed
+		case wsOpBinary:
+			return nil, errWsBinary
+		case wsOpText:
+			if fragmented {
+				return nil, errWsProtocol
+			}
+			message = payload
+			fragmented = !fin
+		case wsOpContinuation:
+			if !fragmented {
+				return nil, errWsProtocol
+			}
+			if len(message)+len(payload) > wsMaxMessageSize {
+				return nil, errWsTooBig
+			}
+			message = append(message, payload...)
+			fragmented = !fin
+		default:
+			return nil, errWsProtocol
+		}
+
+		if !fragmented {
+			return message, nil
+		}
+	}
+}